@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
+	"github.com/sirupsen/logrus"
+
+	"github.com/wakeful/selenium_grid_exporter/collector"
+	"github.com/wakeful/selenium_grid_exporter/internal/gridmock"
+)
+
+// benchOptions are the flags of the bench command.
+type benchOptions struct {
+	url            string
+	concurrency    int
+	duration       time.Duration
+	requests       int
+	timeout        time.Duration
+	scrapeInterval time.Duration
+	mockListen     string
+	mock           mockGrid
+	format         string
+	maxP99         time.Duration
+}
+
+// benchResult is the report of a bench run, printed as text or JSON.
+type benchResult struct {
+	Target            string  `json:"target"`
+	Requests          int     `json:"requests"`
+	Errors            int     `json:"errors"`
+	Seconds           float64 `json:"seconds"`
+	RequestsPerSecond float64 `json:"requestsPerSecond"`
+	BytesPerResponse  float64 `json:"bytesPerResponse"`
+	Latency           struct {
+		P50 float64 `json:"p50"`
+		P90 float64 `json:"p90"`
+		P99 float64 `json:"p99"`
+		Max float64 `json:"max"`
+	} `json:"latencySeconds"`
+	// Allocations of the exporter, unknown for a running exporter which
+	// doesn't export the Go runtime metrics.
+	AllocBytesPerRequest *float64 `json:"allocBytesPerRequest,omitempty"`
+	AllocsPerRequest     *float64 `json:"allocsPerRequest,omitempty"`
+	GCCycles             *float64 `json:"gcCycles,omitempty"`
+}
+
+// benchSample is the outcome of a single metrics request.
+type benchSample struct {
+	latency time.Duration
+	bytes   int
+	err     error
+}
+
+/*
+runBench implements the bench command: it sends concurrent metrics requests
+to a running exporter given with -url, or to an in-process exporter scraping a
+mock Grid, and reports the latency percentiles and the allocations per
+request. It returns the exit status: 1 when the run couldn't be made, a
+request failed or the p99 latency exceeds -max-p99.
+*/
+func runBench(args []string) int {
+	opts := benchOptions{}
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fs.StringVar(&opts.url, "url", "", "Metrics URL of a running exporter, e.g. http://localhost:8080/metrics; an in-process exporter scraping the mock Grid is benchmarked when empty.")
+	fs.IntVar(&opts.concurrency, "concurrency", 8, "Number of concurrent metrics requests.")
+	fs.DurationVar(&opts.duration, "duration", 10*time.Second, "Duration of the run.")
+	fs.IntVar(&opts.requests, "requests", 0, "Stop after this number of requests, before -duration elapsed; 0 for no limit.")
+	fs.DurationVar(&opts.timeout, "timeout", 10*time.Second, "Timeout of a metrics request, and of the scrapes of the in-process exporter.")
+	fs.DurationVar(&opts.scrapeInterval, "scrape-interval", 0, "Background scrape interval of the in-process exporter; 0 scrapes the mock Grid on every request.")
+	fs.StringVar(&opts.mockListen, "mock-listen", "", "Address to serve the mock Grid on, for a running exporter to scrape it; a random loopback port for the in-process exporter when empty.")
+	fs.IntVar(&opts.mock.Nodes, "nodes", 100, "Number of nodes of the mock Grid.")
+	fs.IntVar(&opts.mock.Slots, "slots", 4, "Number of slots per node of the mock Grid.")
+	fs.Float64Var(&opts.mock.Busy, "busy", 0.5, "Ratio of the slots of the mock Grid running a session.")
+	fs.IntVar(&opts.mock.Queue, "queue", 0, "Number of queued session requests of the mock Grid.")
+	fs.StringVar(&opts.format, "format", "text", "Format of the report: text or json.")
+	fs.DurationVar(&opts.maxP99, "max-p99", 0, "Exit with status 1 when the p99 latency exceeds this duration; 0 disables the check.")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if opts.format != "text" && opts.format != "json" {
+		logrus.Errorf("Unknown -format %q, expected text or json", opts.format)
+		return 2
+	}
+	if opts.concurrency < 1 || opts.mock.Nodes < 0 || opts.mock.Slots < 1 || opts.mock.Busy < 0 || opts.mock.Busy > 1 {
+		logrus.Error("Invalid bench options, expected a positive concurrency and slot count and a busy ratio in [0, 1]")
+		return 2
+	}
+	// The exporter logs every scrape failure, which would drown the report.
+	if !logrus.IsLevelEnabled(logrus.DebugLevel) {
+		logrus.SetLevel(logrus.WarnLevel)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A running exporter scrapes the Grid it is configured with, the mock
+	// one only when it is pointed at -mock-listen.
+	var mockURL string
+	if opts.url == "" || opts.mockListen != "" {
+		var stopMock func()
+		var err error
+		if mockURL, stopMock, err = opts.mock.serve(opts.mockListen); err != nil {
+			logrus.Errorf("Failed to serve the mock Grid: %v", err)
+			return 1
+		}
+		defer stopMock()
+	}
+
+	var result *benchResult
+	if opts.url != "" {
+		if mockURL != "" {
+			fmt.Fprintf(os.Stderr, "Serving the mock Grid on %s\n", mockURL)
+		}
+		result = benchRemote(opts)
+	} else {
+		result = benchInProcess(ctx, opts, mockURL)
+	}
+
+	if err := result.write(os.Stdout, opts.format); err != nil {
+		logrus.Errorf("Failed to write the report: %v", err)
+		return 1
+	}
+	switch {
+	case result.Requests == 0:
+		logrus.Error("No request completed")
+		return 1
+	case result.Errors > 0:
+		return 1
+	case opts.maxP99 > 0 && result.Latency.P99 > opts.maxP99.Seconds():
+		logrus.Errorf("p99 latency %s exceeds -max-p99 %s", seconds(result.Latency.P99), opts.maxP99)
+		return 1
+	}
+	return 0
+}
+
+// benchInProcess benchmarks the metrics handler of an exporter scraping the
+// mock Grid at uri, calling it directly so only the exporter is measured.
+func benchInProcess(ctx context.Context, opts benchOptions, uri string) *benchResult {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector.NewCollector(collector.Options{
+		Name:           "bench",
+		URI:            uri,
+		Timeout:        opts.timeout,
+		ScrapeInterval: opts.scrapeInterval,
+		Context:        ctx,
+	}))
+	handler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+
+	request := func() benchSample {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		start := time.Now()
+		handler.ServeHTTP(rec, req)
+		s := benchSample{latency: time.Since(start), bytes: rec.Body.Len()}
+		if rec.Code != http.StatusOK {
+			s.err = fmt.Errorf("unexpected HTTP status %d", rec.Code)
+		}
+		return s
+	}
+
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	result := hammer(opts, request)
+	runtime.ReadMemStats(&after)
+
+	result.Target = "in-process exporter, mock Grid at " + uri
+	if result.Requests > 0 {
+		allocBytes := float64(after.TotalAlloc-before.TotalAlloc) / float64(result.Requests)
+		allocs := float64(after.Mallocs-before.Mallocs) / float64(result.Requests)
+		cycles := float64(after.NumGC - before.NumGC)
+		result.AllocBytesPerRequest, result.AllocsPerRequest, result.GCCycles = &allocBytes, &allocs, &cycles
+	}
+	return result
+}
+
+// benchRemote benchmarks a running exporter. Its allocations are read from
+// its Go runtime metrics, which it only exports with -debug.
+func benchRemote(opts benchOptions) *benchResult {
+	client := &http.Client{Timeout: opts.timeout}
+	request := func() benchSample {
+		req, err := http.NewRequest("GET", opts.url, nil)
+		if err != nil {
+			return benchSample{err: err}
+		}
+		// Asking for gzip explicitly keeps the body compressed, as sent to
+		// Prometheus.
+		req.Header.Set("Accept-Encoding", "gzip")
+		start := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			return benchSample{latency: time.Since(start), err: err}
+		}
+		defer resp.Body.Close()
+		n, err := io.Copy(io.Discard, resp.Body)
+		s := benchSample{latency: time.Since(start), bytes: int(n), err: err}
+		if resp.StatusCode != http.StatusOK {
+			s.err = fmt.Errorf("unexpected HTTP status: %s", resp.Status)
+		}
+		return s
+	}
+
+	before, beforeErr := remoteMemStats(client, opts.url)
+	result := hammer(opts, request)
+	after, afterErr := remoteMemStats(client, opts.url)
+
+	result.Target = opts.url
+	if beforeErr != nil || afterErr != nil {
+		logrus.Warnf("Allocations of the exporter are unknown, run it with -debug to export them")
+	} else if result.Requests > 0 {
+		// The reads of the runtime metrics add about one request.
+		requests := float64(result.Requests + 1)
+		allocBytes := (after["go_memstats_alloc_bytes_total"] - before["go_memstats_alloc_bytes_total"]) / requests
+		allocs := (after["go_memstats_mallocs_total"] - before["go_memstats_mallocs_total"]) / requests
+		cycles := after["go_gc_duration_seconds_count"] - before["go_gc_duration_seconds_count"]
+		result.AllocBytesPerRequest, result.AllocsPerRequest, result.GCCycles = &allocBytes, &allocs, &cycles
+	}
+	return result
+}
+
+// remoteMemStats reads the allocation counters of a running exporter from
+// its metrics.
+func remoteMemStats(client *http.Client, url string) (map[string]float64, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := map[string]float64{}
+	for _, name := range []string{"go_memstats_alloc_bytes_total", "go_memstats_mallocs_total"} {
+		family, ok := families[name]
+		if !ok || len(family.GetMetric()) == 0 {
+			return nil, fmt.Errorf("no %s metric", name)
+		}
+		stats[name] = family.GetMetric()[0].GetCounter().GetValue()
+	}
+	if family, ok := families["go_gc_duration_seconds"]; ok && len(family.GetMetric()) > 0 {
+		stats["go_gc_duration_seconds_count"] = float64(family.GetMetric()[0].GetSummary().GetSampleCount())
+	}
+	return stats, nil
+}
+
+// hammer sends requests from opts.concurrency workers until opts.duration
+// elapsed or opts.requests were sent, and summarizes their samples.
+func hammer(opts benchOptions, request func() benchSample) *benchResult {
+	deadline := time.Now().Add(opts.duration)
+	var sent atomic.Int64
+	samples := make([][]benchSample, opts.concurrency)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for w := range samples {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				if opts.requests > 0 && sent.Add(1) > int64(opts.requests) {
+					return
+				}
+				samples[w] = append(samples[w], request())
+			}
+		}(w)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	result := &benchResult{Seconds: elapsed.Seconds()}
+	var latencies []time.Duration
+	var bytes int
+	for _, worker := range samples {
+		for _, s := range worker {
+			result.Requests++
+			if s.err != nil {
+				result.Errors++
+				if result.Errors == 1 {
+					logrus.Errorf("Metrics request failed: %v", s.err)
+				}
+				continue
+			}
+			latencies = append(latencies, s.latency)
+			bytes += s.bytes
+		}
+	}
+	result.RequestsPerSecond = float64(result.Requests) / elapsed.Seconds()
+	if len(latencies) == 0 {
+		return result
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	result.BytesPerResponse = float64(bytes) / float64(len(latencies))
+	result.Latency.P50 = percentile(latencies, 0.5).Seconds()
+	result.Latency.P90 = percentile(latencies, 0.9).Seconds()
+	result.Latency.P99 = percentile(latencies, 0.99).Seconds()
+	result.Latency.Max = latencies[len(latencies)-1].Seconds()
+	return result
+}
+
+// percentile returns the nearest-rank percentile p of sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+func (r *benchResult) write(w io.Writer, format string) error {
+	if format == "json" {
+		return json.NewEncoder(w).Encode(r)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Target:      %s\n", r.Target)
+	fmt.Fprintf(&b, "Requests:    %d in %s (%.1f/s), %d failed\n", r.Requests, seconds(r.Seconds).Round(time.Millisecond), r.RequestsPerSecond, r.Errors)
+	fmt.Fprintf(&b, "Response:    %.0f bytes\n", r.BytesPerResponse)
+	fmt.Fprintf(&b, "Latency:     p50 %s, p90 %s, p99 %s, max %s\n", seconds(r.Latency.P50), seconds(r.Latency.P90), seconds(r.Latency.P99), seconds(r.Latency.Max))
+	if r.AllocBytesPerRequest != nil {
+		fmt.Fprintf(&b, "Allocations: %.0f bytes and %.0f objects per request, %.0f GC cycles\n", *r.AllocBytesPerRequest, *r.AllocsPerRequest, *r.GCCycles)
+	} else {
+		fmt.Fprintf(&b, "Allocations: unknown\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second)).Round(time.Microsecond)
+}
+
+// mockGrid answers the GraphQL query of the exporter with a Grid of the
+// given size, rendered once. Introspection is not supported and /status
+// answers 404, so the exporter sends its full query.
+type mockGrid struct {
+	gridmock.Grid
+}
+
+// serve starts the mock Grid on addr, a random loopback port when empty, and
+// returns its URL.
+func (m mockGrid) serve(addr string) (string, func(), error) {
+	body, err := m.Response()
+	if err != nil {
+		return "", nil, err
+	}
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	})
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go server.Serve(listener)
+	return "http://" + listener.Addr().String(), func() { server.Close() }, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// newTransport builds the transport used to reach Selenium Grid, applying the
+// optional TLS settings (custom CA, client certificate, skip-verify) and proxy.
+func newTransport(caFile, certFile, keyFile string, insecureSkipVerify bool, proxyURL string) (*http.Transport, error) {
+	tlsConfig, err := newTLSConfig(caFile, certFile, keyFile, insecureSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := newProxy(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.Proxy = proxy
+	return transport, nil
+}
+
+/*
+newProxy returns the proxy selection of the transport. Without a proxy URL the
+HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables apply; with one,
+every request goes through it except for the hosts listed in NO_PROXY.
+Credentials in the URL are sent as proxy basic authentication.
+*/
+func newProxy(proxyURL string) (func(*http.Request) (*url.URL, error), error) {
+	if proxyURL == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing proxy URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, expected http, https or socks5", u.Scheme)
+	}
+
+	noProxy := os.Getenv("NO_PROXY")
+	if noProxy == "" {
+		noProxy = os.Getenv("no_proxy")
+	}
+	proxy := (&httpproxy.Config{HTTPProxy: proxyURL, HTTPSProxy: proxyURL, NoProxy: noProxy}).ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}, nil
+}
+
+func newTLSConfig(caFile, certFile, keyFile string, insecureSkipVerify bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+
+	if caFile != "" {
+		caCert, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file: %w", err)
+		}
+		caPool := x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no valid certificates found in CA file %s", caFile)
+		}
+		tlsConfig.RootCAs = caPool
+	}
+
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("both a client certificate and key file must be provided")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,117 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// adaptiveTimeoutWindow is the number of recent requests the latency
+	// percentile is computed over.
+	adaptiveTimeoutWindow = 100
+	// adaptiveTimeoutMinSamples is the number of requests observed before
+	// the timeout adapts; the maximum applies until then.
+	adaptiveTimeoutMinSamples = 10
+)
+
+/*
+adaptiveTimeout derives the scrape timeout from the recent Grid latency: a
+percentile of the last requests times a multiplier, bounded by min and max.
+Requests are timed up to the end of their body, as the timeout also covers
+reading it, and those which timed out count with the timeout they hit, so a
+latency regression raises the timeout on the next scrape instead of failing
+every one, while a hung Grid is still cut off at max.
+*/
+type adaptiveTimeout struct {
+	percentile, multiplier float64
+	min, max               time.Duration
+
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+
+	current prometheus.Gauge
+}
+
+func newAdaptiveTimeout(percentile, multiplier float64, min, max time.Duration, labels prometheus.Labels) *adaptiveTimeout {
+	a := &adaptiveTimeout{
+		percentile: percentile,
+		multiplier: multiplier,
+		min:        min,
+		max:        max,
+		current: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   Namespace,
+			Subsystem:   ExporterSubsystem,
+			Name:        "scrape_timeout_seconds",
+			Help:        "Timeout currently applied to the scrapes of Selenium Grid.",
+			ConstLabels: labels,
+		}),
+	}
+	a.current.Set(max.Seconds())
+	return a
+}
+
+// observe records the duration of a request which got a response or timed
+// out; other transport errors say nothing about the Grid latency.
+func (a *adaptiveTimeout) observe(d time.Duration, err error) {
+	if err != nil && !isTimeout(err) {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.samples) < adaptiveTimeoutWindow {
+		a.samples = append(a.samples, d)
+	} else {
+		a.samples[a.next] = d
+	}
+	a.next = (a.next + 1) % adaptiveTimeoutWindow
+	a.current.Set(a.timeoutLocked().Seconds())
+}
+
+func (a *adaptiveTimeout) timeout() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.timeoutLocked()
+}
+
+func (a *adaptiveTimeout) timeoutLocked() time.Duration {
+	if len(a.samples) < adaptiveTimeoutMinSamples {
+		return a.max
+	}
+	sorted := append([]time.Duration(nil), a.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(math.Ceil(a.percentile*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+
+	timeout := time.Duration(float64(sorted[rank]) * a.multiplier)
+	if timeout < a.min {
+		return a.min
+	}
+	if timeout > a.max {
+		return a.max
+	}
+	return timeout
+}
+
+func (a *adaptiveTimeout) Describe(ch chan<- *prometheus.Desc) {
+	a.current.Describe(ch)
+}
+
+func (a *adaptiveTimeout) Collect(ch chan<- prometheus.Metric) {
+	a.current.Collect(ch)
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
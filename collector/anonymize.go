@@ -0,0 +1,91 @@
+package collector
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/url"
+	"strings"
+)
+
+/*
+Anonymizer replaces hostnames, URIs and target names by pseudonyms derived
+with a keyed hash: the same value always maps to the same pseudonym, so
+dashboards and alerts keep working, while without the key a pseudonym can't
+be matched against a list of likely hostnames. A nil Anonymizer returns
+values as is.
+*/
+type Anonymizer struct {
+	key []byte
+}
+
+// NewAnonymizer returns an Anonymizer deriving the pseudonyms from key.
+func NewAnonymizer(key string) *Anonymizer {
+	return &Anonymizer{key: []byte(key)}
+}
+
+func (p *Anonymizer) hash(value string) string {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))[:12]
+}
+
+// Pseudonym pseudonymizes an arbitrary label value such as a grid name.
+func (p *Anonymizer) Pseudonym(value string) string {
+	if p == nil || value == "" {
+		return value
+	}
+	return "anon-" + p.hash(value)
+}
+
+// host pseudonymizes a hostname or IP address.
+func (p *Anonymizer) host(host string) string {
+	if p == nil || host == "" {
+		return host
+	}
+	return "host-" + p.hash(host)
+}
+
+// uri pseudonymizes the host of a URI, keeping the scheme and port so the
+// pseudonym still tells a node from a hub. Credentials, path and query are
+// dropped as they may carry project names too.
+func (p *Anonymizer) uri(uri string) string {
+	if p == nil || uri == "" {
+		return uri
+	}
+	u, err := url.Parse(uri)
+	if err != nil || u.Hostname() == "" {
+		return p.Pseudonym(uri)
+	}
+	host := p.host(u.Hostname())
+	if port := u.Port(); port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+	return (&url.URL{Scheme: u.Scheme, Host: host}).String()
+}
+
+// address pseudonymizes a host:port address.
+func (p *Anonymizer) address(addr string) string {
+	if p == nil {
+		return addr
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return p.host(addr)
+	}
+	return net.JoinHostPort(p.host(host), port)
+}
+
+// message pseudonymizes the host of uri wherever it appears in msg, e.g. in
+// an error message.
+func (p *Anonymizer) message(msg, uri string) string {
+	if p == nil {
+		return msg
+	}
+	u, err := url.Parse(uri)
+	if err != nil || u.Hostname() == "" {
+		return msg
+	}
+	return strings.ReplaceAll(msg, u.Hostname(), p.host(u.Hostname()))
+}
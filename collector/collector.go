@@ -0,0 +1,1212 @@
+/*
+Package collector scrapes Selenium Grid and exposes its state as Prometheus
+metrics. It is the library behind selenium_grid_exporter, for Go programs
+which serve their own registry:
+
+	c := collector.NewCollector(collector.Options{
+		Name:    "qa",
+		URI:     "http://selenium-hub:4444",
+		Timeout: 10 * time.Second,
+	})
+	prometheus.MustRegister(c)
+*/
+package collector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	Namespace         = "selenium"
+	gridSubsystem     = "grid"
+	nodeSubsystem     = "node"
+	ExporterSubsystem = "exporter"
+	nodeIdLabel       = "node_id"
+	nodeUriLabel      = "node_uri"
+	statusLabel       = "status"
+	versionLabel      = "version"
+	GridLabel         = "grid"
+	errorTypeLabel    = "type"
+
+	errorTypeHTTP    = "http"
+	errorTypeDecode  = "decode"
+	errorTypeGraphQL = "graphql"
+	errorTypeHTML    = "html"
+)
+
+// Collector is the prometheus.Collector of a single Grid, built by
+// NewCollector.
+type Collector struct {
+	Name            string // value of the grid label
+	URI             string
+	labels          prometheus.Labels // constant labels of every metric, grid included
+	client          *http.Client
+	nodeScheduler   *nodeStatusScheduler
+	probe           *sessionProbe
+	components      *componentStatus
+	nodeMaintenance *NodeMaintenance
+	maintenance     *MaintenanceSchedule
+	anonymizer      *Anonymizer
+
+	// api selects the Grid API scraped: GraphQL, the REST /status endpoint,
+	// or GraphQL with a fallback to /status when it is not available.
+	api string
+
+	up, totalSlots, maxSession, sessionCount, sessionQueueSize  *prometheus.Desc
+	version, nodeCount, configInfo, slotsUtilization            *prometheus.Desc
+	nodeStatus, nodeMaxSession, nodeSlotCount, nodeSessionCount *prometheus.Desc
+	nodeVersion, nodeSlotStereotypes, nodeInMaintenance         *prometheus.Desc
+	nodeInfo, nodeAvailability, nodeDraining, nodeSlots         *prometheus.Desc
+	orphanedSessions, nodeTopSessions, nodeUtilization          *prometheus.Desc
+	sessionsDemand, sessionsCapacity, sessionsBacklog           *prometheus.Desc
+	browserDemand, browserCapacity, browserDemandRatio          *prometheus.Desc
+	browserUtilization                                          *prometheus.Desc
+	featureSlots, featureNodes, featureSessions                 *prometheus.Desc
+	scrapeDuration, lastSuccessfulScrape, scrapeSequence        *prometheus.Desc
+	familyLastUpdated, dataAge                                  *prometheus.Desc
+	scrapeErrors, anomalies                                     *prometheus.CounterVec
+	graphQLErrors                                               prometheus.Counter
+	requestDuration, stageDuration                              *prometheus.HistogramVec
+	stageErrors                                                 *prometheus.CounterVec
+
+	// fetcher, parser and publisher are the stages of a scrape.
+	fetcher   Fetcher
+	parser    parser
+	publisher publisher
+
+	// scrapeInterval enables background scraping; Collect then serves the
+	// cached snapshot instead of scraping on every request.
+	scrapeInterval time.Duration
+
+	// retries is the number of additional attempts made after a transient
+	// fetch failure, waiting retryBackoff (doubled every attempt) in between.
+	retries      int
+	retryBackoff time.Duration
+
+	// adaptiveTimeout, when set, replaces the client timeout as the budget
+	// of a scrape.
+	adaptiveTimeout *adaptiveTimeout
+
+	// scaler requests the session capabilities and queued requests needed
+	// for the per-browser demand.
+	scaler bool
+
+	// sessionFeatures requests the session capabilities to count the
+	// sessions using VNC and video recording.
+	sessionFeatures bool
+
+	// queryNodes, querySessions and queryQueue enable the GraphQL sub-queries
+	// for nodes, sessions and queued session requests.
+	queryNodes, querySessions, queryQueue bool
+
+	// customFields are the user-defined GraphQL fields, exported through
+	// customDescs.
+	customFields []CustomField
+	customDescs  []*prometheus.Desc
+
+	// introspect builds the query from the fields of the schema, cached in
+	// schema until the Grid version changes.
+	introspect bool
+	schema     *graphQLSchema
+
+	// nodeMetrics enables the per-node series, nodeTop the ranked series of
+	// the busiest nodes.
+	nodeMetrics bool
+	nodeTop     int
+
+	// holdAnomalous keeps serving the previous snapshot for one scrape when
+	// the new one fails validation.
+	holdAnomalous bool
+
+	// serveStaleFor keeps serving the values of the last successful scrape
+	// for this long after the Grid stops answering.
+	serveStaleFor time.Duration
+
+	// instanceID identifies the exporter process in the JSON APIs, seq is
+	// the sequence number of the last snapshot.
+	instanceID string
+	seq        uint64
+
+	mu       sync.Mutex
+	last     *snapshot
+	flightMu sync.Mutex
+	flight   *scrapeFlight
+}
+
+/*
+snapshot is the immutable result of a single scrape. Collect turns it into
+const metrics, so concurrent scrapes never share mutable metric state.
+*/
+type snapshot struct {
+	up       bool
+	api      string   // API the data was scraped from
+	grid     *hubGrid // last successfully scraped grid, kept on failure
+	nodes    []snapshotNode
+	orphaned *float64 // only known with node status scraping enabled
+	held     bool     // grid and node values repeated from the previous snapshot
+	stale    bool     // values of the last successful scrape served after a failure
+	seq      uint64   // increased by one every scrape, continued from a peer
+	custom   []customSample
+
+	// familyUpdated is when each metric family was last refreshed, kept
+	// from the previous snapshot for the families this one did not refresh.
+	familyUpdated map[string]time.Time
+
+	browserDemand   map[string]float64 // only known with -scaler
+	browserSessions map[string]float64 // only known with -scaler and sessions
+	featureUsage    map[string]float64 // only known with session capabilities
+	at              time.Time          // start of the scrape
+	duration        time.Duration
+	parseDuration   time.Duration // time spent in the parse stage
+	lastSuccess     time.Time
+	lastError       string // redacted, kept until the next failure
+	lastErrorAt     time.Time
+}
+
+func (s *snapshot) fail(format string, args ...interface{}) {
+	s.lastError = redact(fmt.Sprintf(format, args...))
+	s.lastErrorAt = time.Now()
+}
+
+type snapshotNode struct {
+	HubResponseNode
+	stereotypes []Stereotype
+	maintenance bool // excluded from derived metrics
+}
+
+// scrapeFlight is a scrape in progress which concurrent callers wait for.
+type scrapeFlight struct {
+	done chan struct{}
+	snap *snapshot
+}
+
+type hubResponse struct {
+	Errors []graphQLError `json:"errors"`
+	Data   struct {
+		Grid      hubGrid `json:"grid"`
+		NodesInfo struct {
+			Nodes []HubResponseNode `json:"nodes"`
+		} `json:"nodesInfo"`
+		SessionsInfo struct {
+			Sessions             []hubSession `json:"sessions"`
+			SessionQueueRequests []string     `json:"sessionQueueRequests"`
+		} `json:"sessionsInfo"`
+	} `json:"data"`
+
+	custom []customSample // values of the custom fields
+}
+
+type hubSession struct {
+	Id                    string      `json:"id"`
+	NodeId                string      `json:"nodeId"`
+	SessionDurationMillis json.Number `json:"sessionDurationMillis"`
+	Capabilities          string      `json:"capabilities"`
+}
+
+// startedAt derives the session start from its reported duration.
+func (s hubSession) startedAt(now time.Time) time.Time {
+	millis, err := s.SessionDurationMillis.Int64()
+	if err != nil {
+		return now
+	}
+	return now.Add(-time.Duration(millis) * time.Millisecond)
+}
+
+type hubGrid struct {
+	TotalSlots       float64  `json:"totalSlots"`
+	MaxSession       float64  `json:"maxSession"`
+	SessionCount     float64  `json:"sessionCount"`
+	SessionQueueSize *float64 `json:"sessionQueueSize"` // not reported by the /status API
+	NodeCount        float64  `json:"nodeCount"`
+	Version          string   `json:"version"`
+	Uri              string   `json:"uri"` // external URI, not reported by the /status API
+}
+
+type graphQLError struct {
+	Message string `json:"message"`
+}
+
+type HubResponseNode struct {
+	Id           string     `json:"id"`
+	Uri          string     `json:"uri"`
+	Status       string     `json:"status"`
+	MaxSession   float64    `json:"maxSession"`
+	SlotCount    float64    `json:"slotCount"`
+	SessionCount float64    `json:"sessionCount"`
+	Version      string     `json:"version"`
+	Stereotypes  string     `json:"stereotypes"`
+	OsInfo       nodeOsInfo `json:"osInfo"`
+
+	// reservedSlots are slots the distributor holds for a session being
+	// created. Only the /status API reports them.
+	reservedSlots *float64
+}
+
+type nodeOsInfo struct {
+	Arch    string `json:"arch"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// nodeAvailabilities are the states a node can report, exported as an enum.
+var nodeAvailabilities = []string{"UP", "DRAINING", "DOWN"}
+
+type Stereotype struct {
+	Slots      int `json:"slots"`
+	Stereotype struct {
+		BrowserName    string         `json:"browserName"`
+		BrowserVersion string         `json:"browserVersion"`
+		PlatformName   string         `json:"platformName"`
+		VNCEnabled     capabilityFlag `json:"se:vncEnabled"`
+		RecordVideo    capabilityFlag `json:"se:recordVideo"`
+	} `json:"stereotype"`
+}
+
+func newCollector(name, uri string, labels prometheus.Labels, client *http.Client) *Collector {
+	logrus.Infof("Collecting data from: %s (grid %q)", uri, name)
+
+	e := &Collector{
+		Name:   name,
+		URI:    uri,
+		labels: labels,
+		client: client,
+		up: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, gridSubsystem, "up"),
+			"Was the last scrape of Selenium Grid successful.",
+			nil, labels),
+		totalSlots: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, gridSubsystem, "total_slots"),
+			"Total number of slots.",
+			nil, labels),
+		slotsUtilization: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, gridSubsystem, "slots_utilization"),
+			"Ratio of the active sessions to the total slots, unset without slots.",
+			nil, labels),
+		maxSession: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, gridSubsystem, "max_session"),
+			"Maximum number of sessions.",
+			nil, labels),
+		sessionCount: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, gridSubsystem, "session_count"),
+			"Number of active sessions.",
+			nil, labels),
+		sessionQueueSize: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, gridSubsystem, "session_queue_size"),
+			"Number of queued sessions.",
+			nil, labels),
+		nodeCount: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, gridSubsystem, "node_count"),
+			"Number of nodes.",
+			nil, labels),
+		version: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, gridSubsystem, "version"),
+			"Hub/Router version.",
+			[]string{versionLabel}, labels),
+		configInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, gridSubsystem, "config_info"),
+			"Grid configuration as reported by the GraphQL API.",
+			[]string{"external_uri"}, labels),
+		nodeStatus: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, nodeSubsystem, "status"),
+			"Node status.",
+			[]string{nodeIdLabel, nodeUriLabel, statusLabel}, labels),
+		nodeMaxSession: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, nodeSubsystem, "max_session"),
+			"Maximum number of sessions on node.",
+			[]string{nodeIdLabel, nodeUriLabel}, labels),
+		nodeSlotCount: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, nodeSubsystem, "slot_count"),
+			"Number of slots on node.",
+			[]string{nodeIdLabel, nodeUriLabel}, labels),
+		nodeSessionCount: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, nodeSubsystem, "session_count"),
+			"Number of active sessions on node.",
+			[]string{nodeIdLabel, nodeUriLabel}, labels),
+		nodeUtilization: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, nodeSubsystem, "utilization"),
+			"Ratio of the active sessions on node to the sessions it can run, the lower of its slots and maximum sessions.",
+			[]string{nodeIdLabel, nodeUriLabel}, labels),
+		nodeVersion: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, nodeSubsystem, "version"),
+			"Node version.",
+			[]string{nodeIdLabel, nodeUriLabel, versionLabel}, labels),
+		nodeSlotStereotypes: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, nodeSubsystem, "slot"),
+			"Selenium node slot with browser stereotypes as labels.",
+			[]string{
+				nodeIdLabel,       // Node ID
+				"slot_id",         // Slot ID
+				"browser_name",    // Browser name
+				"browser_version", // Browser version
+				"platform_name",   // Platform name
+			}, labels),
+		nodeInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, nodeSubsystem, "info"),
+			"Node operating system information.",
+			[]string{nodeIdLabel, nodeUriLabel, "arch", "os", "os_version"}, labels),
+		nodeAvailability: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, nodeSubsystem, "availability"),
+			"Node availability, one series per state (UP, DRAINING, DOWN) with the current one set to 1.",
+			[]string{nodeIdLabel, nodeUriLabel, "availability"}, labels),
+		nodeDraining: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, nodeSubsystem, "draining"),
+			"Whether the node is being drained and accepts no new sessions.",
+			[]string{nodeIdLabel, nodeUriLabel}, labels),
+		nodeSlots: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, nodeSubsystem, "slots"),
+			"Number of node slots by state (free, reserved, in_use); reserved slots are only known from the /status API.",
+			[]string{nodeIdLabel, nodeUriLabel, "state"}, labels),
+		nodeInMaintenance: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, nodeSubsystem, "maintenance"),
+			"Whether the node is marked as in planned maintenance.",
+			[]string{nodeIdLabel, nodeUriLabel}, labels),
+		nodeTopSessions: newTopNodesDesc("top_sessions",
+			"Number of running sessions of the busiest nodes, ranked from 1.", labels),
+		orphanedSessions: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, gridSubsystem, "orphaned_sessions"),
+			"Number of sessions known to the hub which no node claims.",
+			nil, labels),
+		sessionsDemand: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, gridSubsystem, "sessions_demand"),
+			"Number of running and queued sessions.",
+			nil, labels),
+		sessionsCapacity: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, gridSubsystem, "sessions_capacity"),
+			"Number of sessions the UP nodes which are not in maintenance can run.",
+			nil, labels),
+		sessionsBacklog: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, gridSubsystem, "sessions_backlog"),
+			"Number of sessions exceeding the capacity of the Grid.",
+			nil, labels),
+		browserDemand: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, gridSubsystem, "browser_demand"),
+			"Number of running and queued sessions per browser.",
+			[]string{browserNameLabel}, labels),
+		browserCapacity: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, gridSubsystem, "browser_capacity"),
+			"Number of sessions per browser the UP nodes which are not in maintenance can run.",
+			[]string{browserNameLabel}, labels),
+		browserDemandRatio: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, gridSubsystem, "browser_demand_ratio"),
+			"Ratio of the demand to the capacity per browser.",
+			[]string{browserNameLabel}, labels),
+		browserUtilization: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, gridSubsystem, "browser_utilization"),
+			"Ratio of the running sessions to the capacity per browser.",
+			[]string{browserNameLabel}, labels),
+		featureSlots: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, gridSubsystem, "feature_slots"),
+			"Number of slots of the UP nodes which are not in maintenance supporting a debugging feature (vnc, video).",
+			[]string{featureLabel}, labels),
+		featureNodes: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, gridSubsystem, "feature_nodes"),
+			"Number of UP nodes which are not in maintenance with slots supporting a debugging feature (vnc, video).",
+			[]string{featureLabel}, labels),
+		featureSessions: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, gridSubsystem, "feature_sessions"),
+			"Number of running sessions using a debugging feature (vnc, video).",
+			[]string{featureLabel}, labels),
+		scrapeDuration: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, ExporterSubsystem, "scrape_duration_seconds"),
+			"Duration of the last scrape of Selenium Grid.",
+			nil, labels),
+		lastSuccessfulScrape: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, ExporterSubsystem, "last_successful_scrape_timestamp_seconds"),
+			"Unix timestamp of the last successful scrape of Selenium Grid.",
+			nil, labels),
+		familyLastUpdated: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, ExporterSubsystem, "family_last_updated_seconds"),
+			"Unix timestamp of the last successful refresh of a metric family (grid, nodes, sessions, queue).",
+			[]string{familyLabel}, labels),
+		dataAge: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, ExporterSubsystem, "data_age_seconds"),
+			"Age of the served Selenium Grid data, the time since the last successful scrape.",
+			nil, labels),
+		scrapeSequence: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, ExporterSubsystem, "scrape_sequence"),
+			"Sequence number of the last scrape, increased by one every scrape.",
+			nil, labels),
+		scrapeErrors: newScrapeErrorsCounter(labels),
+		anomalies:    newAnomaliesCounter(labels),
+		graphQLErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   Namespace,
+			Subsystem:   ExporterSubsystem,
+			Name:        "graphql_errors_total",
+			Help:        "Number of errors returned by the Grid GraphQL API.",
+			ConstLabels: labels,
+		}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   Namespace,
+			Subsystem:   ExporterSubsystem,
+			Name:        "grid_request_duration_seconds",
+			Help:        "Latency of the requests to Selenium Grid by path, up to the end of the response body, including failed ones.",
+			ConstLabels: labels,
+			Buckets:     prometheus.ExponentialBuckets(0.005, 2, 12),
+		}, []string{"path"}),
+		parser: &gridParser{},
+	}
+	e.stageDuration, e.stageErrors = newStageMetrics(labels)
+	e.fetcher = &httpFetcher{uri: uri, client: client, observe: e.observeRequest}
+	e.publisher = e
+	return e
+}
+
+func newScrapeErrorsCounter(labels prometheus.Labels) *prometheus.CounterVec {
+	scrapeErrors := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   Namespace,
+		Subsystem:   ExporterSubsystem,
+		Name:        "scrape_errors_total",
+		Help:        "Number of failed scrapes of Selenium Grid by error type.",
+		ConstLabels: labels,
+	}, []string{errorTypeLabel})
+	for _, t := range []string{errorTypeHTTP, errorTypeDecode, errorTypeGraphQL, errorTypeHTML} {
+		scrapeErrors.WithLabelValues(t)
+	}
+	return scrapeErrors
+}
+
+/*
+Describe is called by Prometheus on startup of this monitor. It needs to tell
+the caller about all of the available metrics. It is also called during "unregister".
+*/
+func (e *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range e.collectors() {
+		c.Describe(ch)
+	}
+	ch <- e.up
+	ch <- e.totalSlots
+	ch <- e.slotsUtilization
+	ch <- e.maxSession
+	ch <- e.sessionCount
+	ch <- e.sessionQueueSize
+	ch <- e.nodeCount
+	ch <- e.version
+	ch <- e.configInfo
+	ch <- e.nodeStatus
+	ch <- e.nodeMaxSession
+	ch <- e.nodeSlotCount
+	ch <- e.nodeSessionCount
+	ch <- e.nodeUtilization
+	ch <- e.nodeVersion
+	ch <- e.nodeSlotStereotypes
+	ch <- e.nodeInMaintenance
+	ch <- e.nodeInfo
+	ch <- e.nodeAvailability
+	ch <- e.nodeDraining
+	ch <- e.nodeSlots
+	ch <- e.nodeTopSessions
+	ch <- e.orphanedSessions
+	ch <- e.sessionsDemand
+	ch <- e.sessionsCapacity
+	ch <- e.sessionsBacklog
+	ch <- e.browserDemand
+	ch <- e.browserCapacity
+	ch <- e.browserDemandRatio
+	ch <- e.browserUtilization
+	ch <- e.featureSlots
+	ch <- e.featureNodes
+	ch <- e.featureSessions
+	ch <- e.scrapeDuration
+	ch <- e.lastSuccessfulScrape
+	ch <- e.scrapeSequence
+	ch <- e.familyLastUpdated
+	ch <- e.dataAge
+	for _, desc := range e.customDescs {
+		ch <- desc
+	}
+	e.scrapeErrors.Describe(ch)
+	e.anomalies.Describe(ch)
+	e.graphQLErrors.Describe(ch)
+	e.requestDuration.Describe(ch)
+	e.stageDuration.Describe(ch)
+	e.stageErrors.Describe(ch)
+}
+
+/*
+Collect is called by Prometheus at regular intervals to provide current data
+*/
+func (e *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range e.collectors() {
+		c.Collect(ch)
+	}
+	snap := e.current()
+	if snap != nil {
+		start := time.Now()
+		if err := e.publisher.publish(ch, snap); err != nil {
+			e.stageErrors.WithLabelValues(stagePublish).Inc()
+			logrus.Errorf("Error publishing the metrics of %s: %v", e.Name, err)
+		}
+		e.stageDuration.WithLabelValues(stagePublish).Observe(time.Since(start).Seconds())
+	}
+	e.scrapeErrors.Collect(ch)
+	if snap == nil || !e.maintenance.active(snap.at) {
+		e.anomalies.Collect(ch)
+	}
+	ch <- e.graphQLErrors
+	e.requestDuration.Collect(ch)
+	e.stageDuration.Collect(ch)
+	e.stageErrors.Collect(ch)
+}
+
+// collectors returns the enabled collectors of the Grid besides the scrape.
+func (e *Collector) collectors() []prometheus.Collector {
+	var collectors []prometheus.Collector
+	if e.nodeScheduler != nil {
+		collectors = append(collectors, e.nodeScheduler)
+	}
+	if e.probe != nil {
+		collectors = append(collectors, e.probe)
+	}
+	if e.components != nil {
+		collectors = append(collectors, e.components)
+	}
+	if e.adaptiveTimeout != nil {
+		collectors = append(collectors, e.adaptiveTimeout)
+	}
+	return collectors
+}
+
+// current returns the snapshot to serve: a fresh one in on-demand mode, the
+// latest background scrape otherwise.
+func (e *Collector) current() *snapshot {
+	if e.scrapeInterval == 0 {
+		return e.refresh()
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.last
+}
+
+// publish is the default publisher.
+func (e *Collector) publish(ch chan<- prometheus.Metric, snap *snapshot) error {
+	var errs publishErrors
+	gauge := errs.gauge(ch)
+	// The derived series alerts are built on are left out during a Grid
+	// maintenance window, the raw values keep being exported.
+	alert := gauge
+	if e.maintenance.active(snap.at) {
+		alert = func(*prometheus.Desc, float64, ...string) {}
+	}
+
+	gauge(e.up, boolToFloat(snap.up))
+	gauge(e.scrapeDuration, snap.duration.Seconds())
+	gauge(e.scrapeSequence, float64(snap.seq))
+	if !snap.lastSuccess.IsZero() {
+		gauge(e.lastSuccessfulScrape, float64(snap.lastSuccess.UnixNano())/1e9)
+		gauge(e.dataAge, time.Since(snap.lastSuccess).Seconds())
+	}
+	for family, at := range snap.familyUpdated {
+		gauge(e.familyLastUpdated, float64(at.UnixNano())/1e9, family)
+	}
+
+	if grid := snap.grid; grid != nil {
+		gauge(e.totalSlots, grid.TotalSlots)
+		if grid.TotalSlots > 0 {
+			alert(e.slotsUtilization, grid.SessionCount/grid.TotalSlots)
+		}
+		gauge(e.maxSession, grid.MaxSession)
+		gauge(e.sessionCount, grid.SessionCount)
+		if grid.SessionQueueSize != nil {
+			gauge(e.sessionQueueSize, *grid.SessionQueueSize)
+		}
+		gauge(e.nodeCount, grid.NodeCount)
+		if grid.Version != "" {
+			gauge(e.version, 1.0, grid.Version)
+		}
+		if grid.Uri != "" {
+			gauge(e.configInfo, 1.0, e.anonymizer.uri(grid.Uri))
+		}
+	}
+	if snap.orphaned != nil {
+		alert(e.orphanedSessions, *snap.orphaned)
+	}
+	for _, sample := range snap.custom {
+		gauge(e.customDescs[sample.field], sample.value, sample.labels...)
+	}
+
+	if snap.up || snap.stale {
+		state := scalerStateOf(e.Name, snap)
+		gauge(e.sessionsDemand, state.Demand)
+		gauge(e.sessionsCapacity, state.Capacity)
+		alert(e.sessionsBacklog, state.Backlog)
+		for _, b := range state.Browsers {
+			gauge(e.browserDemand, b.Demand, b.BrowserName)
+			gauge(e.browserCapacity, b.Capacity, b.BrowserName)
+			if b.Ratio != nil {
+				alert(e.browserDemandRatio, *b.Ratio, b.BrowserName)
+			}
+			if b.Utilization != nil {
+				alert(e.browserUtilization, *b.Utilization, b.BrowserName)
+			}
+		}
+	}
+
+	if (snap.up || snap.stale) && (e.queryNodes || snap.api != APIGraphQL) {
+		slots, nodes := fleetFeatures(snap.nodes)
+		for _, f := range features {
+			gauge(e.featureSlots, slots[f], f)
+			gauge(e.featureNodes, nodes[f], f)
+		}
+	}
+	for f, sessions := range snap.featureUsage {
+		gauge(e.featureSessions, sessions, f)
+	}
+
+	if e.nodeTop > 0 {
+		busiest := make([]rankedNode, 0, len(snap.nodes))
+		for _, n := range snap.nodes {
+			busiest = append(busiest, rankedNode{nodeTarget{n.Id, n.Uri}, n.SessionCount})
+		}
+		collectTopNodes(gauge, e.anonymizer, e.nodeTopSessions, busiest, e.nodeTop)
+	}
+	if !e.nodeMetrics {
+		return errs.err()
+	}
+
+	// Building the const metrics of a giant Grid is sharded over the CPUs,
+	// the channel is safe for concurrent senders.
+	forEachShard(len(snap.nodes), func(start, end int) {
+		for _, n := range snap.nodes[start:end] {
+			e.collectNode(gauge, alert, n)
+		}
+	})
+	return errs.err()
+}
+
+func (e *Collector) collectNode(gauge, alert func(*prometheus.Desc, float64, ...string), n snapshotNode) {
+	n.Uri = e.anonymizer.uri(n.Uri)
+	gauge(e.nodeStatus, 1.0, n.Id, n.Uri, n.Status)
+	gauge(e.nodeMaxSession, n.MaxSession, n.Id, n.Uri)
+	gauge(e.nodeSlotCount, n.SlotCount, n.Id, n.Uri)
+	gauge(e.nodeSessionCount, n.SessionCount, n.Id, n.Uri)
+	if capacity := math.Min(n.SlotCount, n.MaxSession); capacity > 0 {
+		alert(e.nodeUtilization, n.SessionCount/capacity, n.Id, n.Uri)
+	}
+	gauge(e.nodeVersion, 1.0, n.Id, n.Uri, n.Version)
+	gauge(e.nodeInMaintenance, boolToFloat(n.maintenance), n.Id, n.Uri)
+	gauge(e.nodeInfo, 1.0, n.Id, n.Uri, n.OsInfo.Arch, n.OsInfo.Name, n.OsInfo.Version)
+	for _, availability := range nodeAvailabilities {
+		gauge(e.nodeAvailability, boolToFloat(n.Status == availability), n.Id, n.Uri, availability)
+	}
+	gauge(e.nodeDraining, boolToFloat(n.Status == "DRAINING"), n.Id, n.Uri)
+
+	free := n.SlotCount - n.SessionCount
+	if n.reservedSlots != nil {
+		free -= *n.reservedSlots
+		gauge(e.nodeSlots, *n.reservedSlots, n.Id, n.Uri, "reserved")
+	}
+	gauge(e.nodeSlots, math.Max(0, free), n.Id, n.Uri, "free")
+	gauge(e.nodeSlots, n.SessionCount, n.Id, n.Uri, "in_use")
+
+	for _, s := range n.stereotypes {
+		gauge(e.nodeSlotStereotypes, 1.0,
+			n.Id,
+			strconv.Itoa(s.Slots),
+			s.Stereotype.BrowserName,
+			s.Stereotype.BrowserVersion,
+			s.Stereotype.PlatformName,
+		)
+	}
+}
+
+/*
+refresh scrapes Selenium Grid, coalescing concurrent callers into a single
+request: callers arriving while a scrape is in flight share its snapshot.
+*/
+func (e *Collector) refresh() *snapshot {
+	e.flightMu.Lock()
+	if flight := e.flight; flight != nil {
+		e.flightMu.Unlock()
+		<-flight.done
+		return flight.snap
+	}
+	flight := &scrapeFlight{done: make(chan struct{})}
+	e.flight = flight
+	e.flightMu.Unlock()
+
+	flight.snap = e.scrape()
+
+	e.flightMu.Lock()
+	e.flight = nil
+	e.flightMu.Unlock()
+	close(flight.done)
+	return flight.snap
+}
+
+// runScrapeLoop refreshes the cached snapshot every scrapeInterval.
+func (e *Collector) runScrapeLoop(ctx context.Context) {
+	ticker := time.NewTicker(e.scrapeInterval)
+	defer ticker.Stop()
+
+	for {
+		e.scrape()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scrape fetches Selenium Grid and stores the resulting snapshot as the latest one.
+func (e *Collector) scrape() *snapshot {
+	start := time.Now()
+
+	e.mu.Lock()
+	prev := e.last
+	e.mu.Unlock()
+
+	snap := &snapshot{at: start}
+	if prev != nil {
+		// The values of the Grid and its nodes are dropped when the Grid
+		// cannot be scraped, unless serveStale keeps them.
+		snap.api = prev.api
+		snap.lastSuccess = prev.lastSuccess
+		snap.lastError = prev.lastError
+		snap.lastErrorAt = prev.lastErrorAt
+		snap.familyUpdated = prev.familyUpdated
+	}
+
+	e.scrapeGrid(prev, snap)
+	if !snap.up && prev != nil {
+		e.serveStale(prev, snap)
+	}
+	snap.duration = time.Since(start)
+	if snap.parseDuration > 0 {
+		e.stageDuration.WithLabelValues(stageParse).Observe(snap.parseDuration.Seconds())
+	}
+
+	e.mu.Lock()
+	e.seq++
+	snap.seq = e.seq
+	e.last = snap
+	e.mu.Unlock()
+	return snap
+}
+
+func (e *Collector) scrapeGrid(prev, snap *snapshot) {
+	var hResponse *hubResponse
+	api := e.api
+	if api == APIGrid3 {
+		hResponse = e.fetchGrid3(snap)
+	} else {
+		hResponse, api = e.fetchGrid4(snap)
+	}
+	if hResponse == nil {
+		return
+	}
+
+	// The Grid answers 200 with an errors array and empty or partial data
+	// when the query fails, which must not be exported as zeros.
+	if len(hResponse.Errors) > 0 {
+		e.scrapeErrors.WithLabelValues(errorTypeGraphQL).Inc()
+		e.stageErrors.WithLabelValues(stageParse).Inc()
+		e.graphQLErrors.Add(float64(len(hResponse.Errors)))
+		logrus.Errorf("Selenium Grid returned %d GraphQL errors: %s", len(hResponse.Errors), hResponse.Errors[0].Message)
+		snap.fail("Selenium Grid returned GraphQL errors: %s", hResponse.Errors[0].Message)
+		return
+	}
+
+	// Building the snapshot from the response is part of the parse stage.
+	start := time.Now()
+	defer func() { snap.parseDuration += time.Since(start) }()
+
+	snap.up = true // Indicate scrape success
+	snap.api = api
+	logrus.Debug("Successfully scraped Selenium Grid")
+
+	snap.lastSuccess = time.Now()
+	snap.grid = &hResponse.Data.Grid
+	e.checkSchemaVersion(snap.grid.Version)
+	snap.custom = hResponse.custom
+
+	now := time.Now()
+	nodes := hResponse.Data.NodesInfo.Nodes
+	snap.nodes = make([]snapshotNode, len(nodes))
+	forEachShard(len(nodes), func(start, end int) {
+		for i := start; i < end; i++ {
+			n := nodes[i]
+			node := snapshotNode{HubResponseNode: n, maintenance: e.nodeMaintenance.matches(n.Uri, now)}
+
+			// Parse stereotypes JSON
+			if err := json.Unmarshal([]byte(n.Stereotypes), &node.stereotypes); err != nil {
+				logrus.Errorf("Error decoding stereotypes for node %s: %v", n.Id, err)
+			}
+			snap.nodes[i] = node
+		}
+	})
+
+	inMaintenance := make(map[string]bool, len(nodes))
+	targets := make([]nodeTarget, 0, len(nodes))
+	for _, n := range snap.nodes {
+		targets = append(targets, nodeTarget{Id: n.Id, Uri: n.Uri})
+		inMaintenance[n.Id] = n.maintenance
+	}
+
+	// Without the sessions sub-query every session would look orphaned.
+	sessionsQueried := api == APIStatus || e.wantSessions()
+	if e.nodeScheduler != nil {
+		e.nodeScheduler.setNodes(targets)
+	}
+	if e.nodeScheduler != nil && sessionsQueried {
+		var sessions []hubSession
+		for _, session := range hResponse.Data.SessionsInfo.Sessions {
+			if !inMaintenance[session.NodeId] {
+				sessions = append(sessions, session)
+			}
+		}
+		orphaned := float64(e.nodeScheduler.orphanedSessions(sessions, now))
+		snap.orphaned = &orphaned
+	}
+	if e.scaler && sessionsQueried {
+		snap.browserSessions = browserSessions(hResponse.Data.SessionsInfo.Sessions)
+	}
+	if e.scaler && (sessionsQueried || e.wantQueue()) {
+		snap.browserDemand = browserDemand(snap.browserSessions, hResponse.Data.SessionsInfo.SessionQueueRequests)
+	}
+	if sessionsQueried && (e.scaler || e.sessionFeatures) {
+		snap.featureUsage = sessionFeatures(hResponse.Data.SessionsInfo.Sessions)
+	}
+
+	e.validate(prev, snap)
+	if snap.held {
+		return
+	}
+	families := []string{familyGrid}
+	if e.queryNodes || api != APIGraphQL {
+		families = append(families, familyNodes)
+	}
+	if sessionsQueried && api != APIGrid3 {
+		families = append(families, familySessions)
+	}
+	if snap.grid.SessionQueueSize != nil {
+		families = append(families, familyQueue)
+	}
+	snap.markUpdated(snap.lastSuccess, families...)
+}
+
+// validate counts anomalies of the new snapshot and, when enabled, replaces
+// its values by the previous ones, unless those were already held once.
+func (e *Collector) validate(prev, snap *snapshot) {
+	anomalies := validateSnapshot(prev, snap)
+	if len(anomalies) == 0 {
+		return
+	}
+	for reason, description := range anomalies {
+		e.anomalies.WithLabelValues(reason).Inc()
+		logrus.Warnf("Anomalous sample from %s: %s", e.Name, description)
+	}
+
+	if e.holdAnomalous && prev != nil && prev.up && !prev.held {
+		logrus.Warnf("Holding the previous values of %s for one scrape", e.Name)
+		snap.keep(prev)
+		snap.held = true
+	}
+}
+
+/*
+serveStale keeps serving the values of the last successful scrape after a
+failed one, as long as they are at most serveStaleFor old, so a hub restart
+doesn't drop every node series. Older values are cleared, the grid totals
+included.
+*/
+func (e *Collector) serveStale(prev, snap *snapshot) {
+	if e.serveStaleFor <= 0 {
+		return
+	}
+	if snap.lastSuccess.IsZero() || time.Since(snap.lastSuccess) > e.serveStaleFor {
+		if prev.stale {
+			logrus.Warnf("Values of %s are older than %s, no longer serving them", e.Name, e.serveStaleFor)
+		}
+		snap.grid = nil
+		return
+	}
+	if !prev.stale {
+		logrus.Warnf("Serving the values of %s from %s for up to %s", e.Name, snap.lastSuccess.Format(time.RFC3339), e.serveStaleFor)
+	}
+	snap.keep(prev)
+	snap.stale = true
+}
+
+// keep repeats the grid and node values of prev.
+func (s *snapshot) keep(prev *snapshot) {
+	s.grid = prev.grid
+	s.nodes = prev.nodes
+	s.orphaned = prev.orphaned
+	s.browserDemand = prev.browserDemand
+	s.browserSessions = prev.browserSessions
+	s.featureUsage = prev.featureUsage
+	s.custom = prev.custom
+}
+
+// fetchGrid4 scrapes a Selenium Grid 4 through the configured API, returning
+// the API actually used. It returns nil when the scrape failed.
+func (e *Collector) fetchGrid4(snap *snapshot) (*hubResponse, string) {
+	api := e.api
+	var hResponse hubResponse
+	var err, parseErr error
+	if api != APIStatus {
+		err = e.stream(graphQLRequest(e.query(e.currentSchema())), func(body io.Reader) {
+			parseErr = e.parse(snap, APIGraphQL, body, &hResponse)
+		})
+		if api == APIAuto && isAPIUnavailable(err) {
+			if snap.api != APIStatus {
+				logrus.Warnf("GraphQL API of %s is not available (%v), falling back to /status", e.Name, err)
+			}
+			api = APIStatus
+		} else {
+			api = APIGraphQL
+		}
+	}
+
+	if api == APIStatus {
+		var body []byte
+		if body, err = e.fetch(statusRequest); err == nil {
+			parseErr = e.parse(snap, api, bytes.NewReader(body), &hResponse)
+		}
+	}
+	if err != nil {
+		e.failFetch(snap, err)
+		return nil, api
+	}
+	if parseErr != nil {
+		e.failParse(snap, parseErr)
+		return nil, api
+	}
+	return &hResponse, api
+}
+
+// parse runs the parser on the answer of api, adding its duration to the
+// parse stage of snap.
+func (e *Collector) parse(snap *snapshot, api string, body io.Reader, hResponse *hubResponse) error {
+	start := time.Now()
+	defer func() { snap.parseDuration += time.Since(start) }()
+	return e.parser.parse(api, body, hResponse)
+}
+
+func (e *Collector) failFetch(snap *snapshot, err error) {
+	e.scrapeErrors.WithLabelValues(errorTypeHTTP).Inc()
+	e.stageErrors.WithLabelValues(stageFetch).Inc()
+	logrus.Errorf("Error scraping Selenium Grid: %v", err)
+	snap.fail("Error scraping Selenium Grid: %v", err)
+}
+
+func (e *Collector) failDecode(snap *snapshot, err error) {
+	logrus.Errorf("Error decoding Selenium Grid response: %v", err)
+	e.scrapeErrors.WithLabelValues(errorTypeDecode).Inc()
+	e.stageErrors.WithLabelValues(stageParse).Inc()
+	snap.fail("Error decoding Selenium Grid response: %v", err)
+}
+
+// isHTML detects HTML error pages: reverse proxies in front of the Grid may
+// answer with one and a 200 status, which would otherwise surface as a
+// cryptic decode error.
+func (e *Collector) isHTML(snap *snapshot, body []byte) bool {
+	if !looksLikeHTML(body) {
+		return false
+	}
+	logrus.Errorf("Selenium Grid returned an HTML page instead of JSON, check for a proxy in between")
+	logrus.Debugf("HTML response snippet: %s", snippet(body, 512))
+	e.scrapeErrors.WithLabelValues(errorTypeHTML).Inc()
+	e.stageErrors.WithLabelValues(stageParse).Inc()
+	snap.fail("Selenium Grid returned an HTML page instead of JSON: %s", snippet(body, 120))
+	return true
+}
+
+/*
+fetch queries Selenium Grid through the fetcher, retrying transient failures
+(network errors and 5xx responses) with exponential backoff. All attempts
+share the HTTP client timeout as overall budget, so retries never extend the
+scrape beyond it.
+*/
+func (e *Collector) fetch(req FetchRequest) ([]byte, error) {
+	ctx, cancel := e.fetchContext()
+	defer cancel()
+	return e.fetchWithin(ctx, req)
+}
+
+// fetchWithin is fetch within the budget of ctx, for scrapes made of several
+// requests.
+func (e *Collector) fetchWithin(ctx context.Context, req FetchRequest) ([]byte, error) {
+	defer e.observeStage(stageFetch, time.Now())
+
+	var body []byte
+	err := e.retry(ctx, func(ctx context.Context) error {
+		resp, err := e.fetcher.Fetch(ctx, req)
+		if err != nil {
+			return err
+		}
+		defer resp.Close()
+		if body, err = io.ReadAll(resp); err != nil {
+			logrus.Errorf("Failed to read response body: %v", err)
+		}
+		return err
+	})
+	return body, err
+}
+
+/*
+stream is fetch for responses decoded while they are read, without holding
+the whole body in memory: decode is called with the body of the first attempt
+answered 200, within the same timeout budget. Failures while reading the body
+are not retried, and reading it counts in the parse stage.
+*/
+func (e *Collector) stream(req FetchRequest, decode func(io.Reader)) error {
+	ctx, cancel := e.fetchContext()
+	defer cancel()
+
+	start := time.Now()
+	var body io.ReadCloser
+	err := e.retry(ctx, func(ctx context.Context) (err error) {
+		body, err = e.fetcher.Fetch(ctx, req)
+		return err
+	})
+	e.observeStage(stageFetch, start)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	decode(body)
+	return nil
+}
+
+func (e *Collector) observeStage(stage string, start time.Time) {
+	e.stageDuration.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+}
+
+// observeRequest records the latency of a request of the HTTP fetcher.
+func (e *Collector) observeRequest(path string, d time.Duration, err error) {
+	e.requestDuration.WithLabelValues(path).Observe(d.Seconds())
+	if e.adaptiveTimeout != nil {
+		e.adaptiveTimeout.observe(d, err)
+	}
+}
+
+// fetchContext bounds the attempts of a fetch by the scrape timeout.
+func (e *Collector) fetchContext() (context.Context, context.CancelFunc) {
+	timeout := e.client.Timeout
+	if e.adaptiveTimeout != nil {
+		timeout = e.adaptiveTimeout.timeout()
+	}
+	if timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+func (e *Collector) retry(ctx context.Context, attempt func(context.Context) error) error {
+	backoff := e.retryBackoff
+	for n := 0; ; n++ {
+		err := attempt(ctx)
+		if err == nil || !retryable(err) || n >= e.retries {
+			return err
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff {
+			logrus.Warnf("Not retrying scrape, timeout budget exhausted: %v", err)
+			return err
+		}
+		logrus.Warnf("Scrape attempt %d of %d failed, retrying in %s: %v", n+1, e.retries+1, backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// wantSessions reports whether the sessions sub-query is enabled and
+// something consumes it: node status scraping cross-checks the sessions, the
+// scaler counts them per browser and the session features per feature.
+func (e *Collector) wantSessions() bool {
+	return e.querySessions && (e.nodeScheduler != nil || e.scaler || e.sessionFeatures)
+}
+
+// wantQueue reports whether the queued requests are enabled and needed for
+// the per-browser demand.
+func (e *Collector) wantQueue() bool {
+	return e.queryQueue && e.scaler
+}
+
+/*
+query builds the GraphQL request body from the enabled sub-queries and the
+custom fields, leaving out the fields the schema of the Grid doesn't have. The
+grid totals are always requested.
+*/
+func (e *Collector) query(schema *graphQLSchema) string {
+	fields := []string{"grid { " + strings.Join(schema.fields(typeGrid, "uri", "totalSlots", "maxSession", "sessionCount", "sessionQueueSize", "nodeCount", "version"), ", ") + " }"}
+	if e.queryNodes {
+		nodeFields := schema.fields(typeNode, "id", "uri", "status", "maxSession", "slotCount", "sessionCount", "version", "stereotypes")
+		if schema.has(typeNode, "osInfo") {
+			nodeFields = append(nodeFields, "osInfo { arch, name, version }")
+		}
+		fields = append(fields, "nodesInfo { nodes { "+strings.Join(nodeFields, ", ")+" } }")
+	}
+
+	var sessionsInfo []string
+	if e.wantQueue() && schema.has(typeSessionsInfo, "sessionQueueRequests") {
+		sessionsInfo = append(sessionsInfo, "sessionQueueRequests")
+	}
+	if e.wantSessions() && schema.has(typeSessionsInfo, "sessions") {
+		sessionFields := schema.fields(typeSession, "id", "nodeId", "sessionDurationMillis")
+		if e.scaler || e.sessionFeatures {
+			sessionFields = append(sessionFields, schema.fields(typeSession, "capabilities")...)
+		}
+		sessionsInfo = append(sessionsInfo, "sessions { "+strings.Join(sessionFields, ", ")+" }")
+	}
+	if len(sessionsInfo) > 0 {
+		fields = append(fields, "sessionsInfo { "+strings.Join(sessionsInfo, ", ")+" }")
+	}
+	fields = append(fields, customQuery(e.customFields, schema)...)
+
+	return `{"query": "{ ` + strings.Join(fields, ", ") + ` }"}`
+}
+
+func graphQLRequest(query string) FetchRequest {
+	return FetchRequest{Path: "/graphql", Body: query}
+}
+
+func looksLikeHTML(body []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(body), []byte("<"))
+}
+
+// snippet returns at most n bytes of body on a single line.
+func snippet(body []byte, n int) string {
+	if len(body) > n {
+		body = body[:n]
+	}
+	return strings.Join(strings.Fields(string(body)), " ")
+}
+
+var userinfoPattern = regexp.MustCompile(`://[^/@\s"]+@`)
+
+// redact removes credentials embedded in URLs from error messages.
+func redact(msg string) string {
+	return userinfoPattern.ReplaceAllString(msg, "://xxxxx@")
+}
+
+// RedactURI hides the password of a URI, e.g. before logging it.
+func RedactURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return redact(uri)
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,136 @@
+package collector
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/wakeful/selenium_grid_exporter/internal/gridmock"
+)
+
+// gridResponse renders the GraphQL answer of a Grid with the given number of
+// nodes, each running one session out of two slots.
+func gridResponse(nodes int) []byte {
+	body, err := gridmock.Grid{Nodes: nodes, Slots: 2, Busy: 0.5}.Response()
+	if err != nil {
+		panic(err)
+	}
+	return body
+}
+
+// gatherSequence collects e and returns the scrape sequence it exported.
+func gatherSequence(t *testing.T, e *Collector) float64 {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		e.Collect(ch)
+		close(ch)
+	}()
+
+	seq := -1.0
+	for m := range ch {
+		if m.Desc() != e.scrapeSequence {
+			continue
+		}
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Error(err)
+			continue
+		}
+		seq = pb.GetGauge().GetValue()
+	}
+	return seq
+}
+
+func TestCollectCoalescesScrapes(t *testing.T) {
+	body := gridResponse(3)
+	var requests atomic.Int32
+	arrived := make(chan struct{}, 1)
+	release := make(chan struct{})
+	grid := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/graphql" {
+			http.NotFound(w, r)
+			return
+		}
+		requests.Add(1)
+		select {
+		case arrived <- struct{}{}:
+		default:
+		}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+	defer grid.Close()
+
+	e := NewCollector(Options{Name: "test", URI: grid.URL})
+
+	const callers = 8
+	for round := 1; round <= 3; round++ {
+		requests.Store(0)
+		seqs := make([]float64, callers)
+		var wg sync.WaitGroup
+		for i := range seqs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				seqs[i] = gatherSequence(t, e)
+			}(i)
+		}
+
+		// Hold the Grid answer until every caller joined the scrape.
+		<-arrived
+		time.Sleep(100 * time.Millisecond)
+		release <- struct{}{}
+		wg.Wait()
+
+		if n := requests.Load(); n != 1 {
+			t.Errorf("round %d: got %d requests to the Grid, want 1", round, n)
+		}
+		for i, seq := range seqs {
+			if seq != float64(round) {
+				t.Errorf("round %d: caller %d got scrape sequence %v, want %d", round, i, seq, round)
+			}
+		}
+	}
+	if status := e.Status(); !status.Up {
+		t.Errorf("Grid is down after the scrapes: %s", status.LastError)
+	}
+}
+
+func TestScrapeFailureDropsGridValues(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		serveStaleFor time.Duration
+		kept          bool
+	}{
+		{"default", 0, false},
+		{"serve stale", time.Minute, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fetcher := replayFetcher{"/graphql": gridResponse(2)}
+			e := NewCollector(Options{Name: "test", URI: "http://grid.test", Fetcher: fetcher, ServeStaleFor: tc.serveStaleFor})
+			if snap := e.scrape(); !snap.up || snap.grid == nil {
+				t.Fatalf("first scrape failed: %s", snap.lastError)
+			}
+
+			delete(fetcher, "/graphql")
+			snap := e.scrape()
+			if snap.up {
+				t.Fatal("scrape of a missing Grid succeeded")
+			}
+			if kept := snap.grid != nil; kept != tc.kept {
+				t.Errorf("got Grid values kept %v after a failed scrape, want %v", kept, tc.kept)
+			}
+		})
+	}
+}
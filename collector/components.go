@@ -0,0 +1,191 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	componentSubsystem = "component"
+	componentLabel     = "component"
+	componentUriLabel  = "component_uri"
+)
+
+// Component is a single Grid server (router, distributor, session map,
+// session queue, event bus, ...) of a Grid running in distributed mode.
+type Component struct {
+	Name string `yaml:"name"`
+	URI  string `yaml:"uri"`
+}
+
+type componentStatusResult struct {
+	up       bool
+	ready    bool
+	version  string
+	duration time.Duration
+}
+
+type componentStatusResponse struct {
+	Value struct {
+		Ready   bool   `json:"ready"`
+		Message string `json:"message"`
+		Version string `json:"version"`
+		Node    struct {
+			Version string `json:"version"`
+		} `json:"node"`
+	} `json:"value"`
+}
+
+// componentStatus polls the /status endpoint of the components of a
+// distributed Grid.
+type componentStatus struct {
+	components []Component
+	client     *http.Client
+	interval   time.Duration
+	// publishFailures counts the collections leaving out metrics.
+	publishFailures prometheus.Counter
+	anonymizer      *Anonymizer
+
+	mu      sync.Mutex
+	results map[string]componentStatusResult
+
+	up, ready, duration, info *prometheus.Desc
+}
+
+func newComponentStatus(components []Component, client *http.Client, interval time.Duration, labels prometheus.Labels) *componentStatus {
+	return &componentStatus{
+		components: components,
+		client:     client,
+		interval:   interval,
+		results:    map[string]componentStatusResult{},
+		up: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, componentSubsystem, "up"),
+			"Was the last request to the component /status endpoint successful.",
+			[]string{componentLabel, componentUriLabel}, labels),
+		ready: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, componentSubsystem, "ready"),
+			"Component readiness as reported by its /status endpoint.",
+			[]string{componentLabel, componentUriLabel}, labels),
+		duration: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, componentSubsystem, "status_duration_seconds"),
+			"Duration of the last request to the component /status endpoint.",
+			[]string{componentLabel, componentUriLabel}, labels),
+		info: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, componentSubsystem, "info"),
+			"Version of the component, when reported by its /status endpoint.",
+			[]string{componentLabel, componentUriLabel, versionLabel}, labels),
+	}
+}
+
+func (c *componentStatus) run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		var wg sync.WaitGroup
+		for _, component := range c.components {
+			wg.Add(1)
+			go func(component Component) {
+				defer wg.Done()
+				c.poll(ctx, component)
+			}(component)
+		}
+		wg.Wait()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *componentStatus) poll(ctx context.Context, component Component) {
+	start := time.Now()
+	result := componentStatusResult{}
+
+	status, err := c.fetch(ctx, component.URI)
+	result.duration = time.Since(start)
+	if err != nil {
+		logrus.Debugf("Error fetching status of component %s: %v", component.Name, err)
+	} else {
+		result.up = true
+		result.ready = status.Value.Ready
+		result.version = status.Value.Version
+		if result.version == "" {
+			result.version = status.Value.Node.Version
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results[component.Name] = result
+}
+
+func (c *componentStatus) fetch(ctx context.Context, uri string) (*componentStatusResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(uri, "/")+"/status", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Components which are not ready answer with 503 and a regular status body.
+	var status componentStatusResponse
+	if err := json.Unmarshal(body, &status); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected HTTP status: %s", resp.Status)
+		}
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (c *componentStatus) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.up
+	ch <- c.ready
+	ch <- c.duration
+	ch <- c.info
+}
+
+func (c *componentStatus) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var errs publishErrors
+	gauge := errs.gauge(ch)
+	for _, component := range c.components {
+		r, polled := c.results[component.Name]
+		if !polled {
+			continue
+		}
+		labels := []string{component.Name, c.anonymizer.uri(component.URI)}
+		gauge(c.up, boolToFloat(r.up), labels...)
+		gauge(c.duration, r.duration.Seconds(), labels...)
+		if r.up {
+			gauge(c.ready, boolToFloat(r.ready), labels...)
+			if r.version != "" {
+				gauge(c.info, 1, append(labels, r.version)...)
+			}
+		}
+	}
+	reportPublish(&errs, "component", c.publishFailures)
+}
@@ -0,0 +1,256 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Objects of the GraphQL schema custom fields can be read from.
+const (
+	CustomObjectGrid     = "grid"
+	CustomObjectNodes    = "nodesInfo"
+	CustomObjectSessions = "sessionsInfo"
+)
+
+var (
+	graphQLNamePattern = regexp.MustCompile(`^[_A-Za-z][_0-9A-Za-z]*$`)
+	metricNamePattern  = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNamePattern   = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+/*
+CustomField declares a GraphQL field the exporter does not know about, which is
+added to the query and exported as a gauge. Fields of nodesInfo and
+sessionsInfo are read from every node and session; samples with the same label
+values are summed, so a field without labels is the total over all of them.
+*/
+type CustomField struct {
+	// Object is grid, nodesInfo or sessionsInfo.
+	Object string `yaml:"object"`
+	// Field is the path of the field below the object, nested fields
+	// separated by dots, e.g. osInfo.version. Numbers are exported as is,
+	// booleans as 0 or 1, lists as their length.
+	Field  string `yaml:"field"`
+	Metric string `yaml:"metric"`
+	Help   string `yaml:"help"`
+	// Labels maps label names to the paths of fields of the same object,
+	// whose values become the label values.
+	Labels map[string]string `yaml:"labels"`
+
+	labelNames []string
+}
+
+// Compile validates the field and its labels, and must be called before the
+// field is passed to NewCollector.
+func (f *CustomField) Compile() error {
+	switch f.Object {
+	case CustomObjectGrid, CustomObjectNodes, CustomObjectSessions:
+	default:
+		return fmt.Errorf("custom field %s: unknown object %q, expected grid, nodesInfo or sessionsInfo", f.Metric, f.Object)
+	}
+	if !metricNamePattern.MatchString(f.Metric) {
+		return fmt.Errorf("custom field %s.%s: invalid metric name %q", f.Object, f.Field, f.Metric)
+	}
+	if !validFieldPath(f.Field) {
+		return fmt.Errorf("custom field %s: invalid field %q", f.Metric, f.Field)
+	}
+
+	f.labelNames = f.labelNames[:0]
+	for name, path := range f.Labels {
+		if !labelNamePattern.MatchString(name) || strings.HasPrefix(name, "__") || name == GridLabel {
+			return fmt.Errorf("custom field %s: invalid label name %q", f.Metric, name)
+		}
+		if !validFieldPath(path) {
+			return fmt.Errorf("custom field %s: invalid field %q of label %s", f.Metric, path, name)
+		}
+		f.labelNames = append(f.labelNames, name)
+	}
+	sort.Strings(f.labelNames)
+	if f.Help == "" {
+		f.Help = "Value of the GraphQL field " + f.Object + "." + f.Field + "."
+	}
+	return nil
+}
+
+func validFieldPath(path string) bool {
+	for _, name := range strings.Split(path, ".") {
+		if !graphQLNamePattern.MatchString(name) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *CustomField) desc(labels prometheus.Labels) *prometheus.Desc {
+	return prometheus.NewDesc(f.Metric, f.Help, f.labelNames, labels)
+}
+
+// customSample is the value of a custom field, summed over the nodes or
+// sessions with the same label values.
+type customSample struct {
+	field  int
+	labels []string
+	value  float64
+}
+
+// fieldTree is the selection set of the custom fields of one object.
+type fieldTree map[string]fieldTree
+
+func (t fieldTree) add(path string) {
+	for _, name := range strings.Split(path, ".") {
+		if t[name] == nil {
+			t[name] = fieldTree{}
+		}
+		t = t[name]
+	}
+}
+
+func (t fieldTree) String() string {
+	names := make([]string, 0, len(t))
+	for name := range t {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		if len(t[name]) > 0 {
+			names[i] = name + " { " + t[name].String() + " }"
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+/*
+customQuery returns the selections of the custom fields the schema of the Grid
+has, to be added to the query next to the built-in ones. GraphQL merges
+repeated selections of the same field, so the response has a single grid,
+nodesInfo and sessionsInfo object.
+*/
+func customQuery(fields []CustomField, schema *graphQLSchema) []string {
+	trees := map[string]fieldTree{}
+	for _, f := range fields {
+		if name, _, _ := strings.Cut(f.Field, "."); !schema.has(customFieldType(f.Object), name) {
+			continue
+		}
+		if trees[f.Object] == nil {
+			trees[f.Object] = fieldTree{}
+		}
+		trees[f.Object].add(f.Field)
+		for _, path := range f.Labels {
+			trees[f.Object].add(path)
+		}
+	}
+
+	var selections []string
+	if t := trees[CustomObjectGrid]; t != nil {
+		selections = append(selections, "grid { "+t.String()+" }")
+	}
+	if t := trees[CustomObjectNodes]; t != nil {
+		selections = append(selections, "nodesInfo { nodes { "+t.String()+" } }")
+	}
+	if t := trees[CustomObjectSessions]; t != nil {
+		selections = append(selections, "sessionsInfo { sessions { "+t.String()+" } }")
+	}
+	return selections
+}
+
+// customSamples extracts the values of the custom fields from a GraphQL
+// response body.
+func customSamples(fields []CustomField, body []byte, anonymizer *Anonymizer) ([]customSample, error) {
+	var response struct {
+		Data struct {
+			Grid      map[string]interface{} `json:"grid"`
+			NodesInfo struct {
+				Nodes []map[string]interface{} `json:"nodes"`
+			} `json:"nodesInfo"`
+			SessionsInfo struct {
+				Sessions []map[string]interface{} `json:"sessions"`
+			} `json:"sessionsInfo"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+
+	var samples []customSample
+	index := map[string]int{}
+	for i, f := range fields {
+		objects := []map[string]interface{}{response.Data.Grid}
+		switch f.Object {
+		case CustomObjectNodes:
+			objects = response.Data.NodesInfo.Nodes
+		case CustomObjectSessions:
+			objects = response.Data.SessionsInfo.Sessions
+		}
+
+		for _, object := range objects {
+			value, ok := customValue(lookupField(object, f.Field))
+			if !ok {
+				continue
+			}
+			labels := make([]string, len(f.labelNames))
+			for j, name := range f.labelNames {
+				labels[j] = customLabelValue(f.Labels[name], lookupField(object, f.Labels[name]), anonymizer)
+			}
+
+			key := strconv.Itoa(i) + "\xff" + strings.Join(labels, "\xff")
+			if j, ok := index[key]; ok {
+				samples[j].value += value
+				continue
+			}
+			index[key] = len(samples)
+			samples = append(samples, customSample{field: i, labels: labels, value: value})
+		}
+	}
+	return samples, nil
+}
+
+func lookupField(object map[string]interface{}, path string) interface{} {
+	var value interface{} = object
+	for _, name := range strings.Split(path, ".") {
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = fields[name]
+	}
+	return value
+}
+
+func customValue(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case bool:
+		return boolToFloat(v), true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	case []interface{}:
+		return float64(len(v)), true
+	}
+	return 0, false
+}
+
+// customLabelValue formats a field as label value, pseudonymizing URIs when
+// anonymizing.
+func customLabelValue(path string, v interface{}, anonymizer *Anonymizer) string {
+	var value string
+	switch v := v.(type) {
+	case string:
+		value = v
+	case float64:
+		value = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		value = strconv.FormatBool(v)
+	}
+	if strings.HasSuffix(strings.ToLower(path), "uri") {
+		value = anonymizer.uri(value)
+	}
+	return value
+}
@@ -0,0 +1,180 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// dnsIdleTimeout is how long the entry of a host no connection is made to is
+// kept, e.g. of a node which was scaled down.
+const dnsIdleTimeout = time.Hour
+
+type dnsEntry struct {
+	addrs    []string
+	expires  time.Time
+	used     time.Time // last lookup
+	lastUsed string    // address of the last successful connection
+}
+
+/*
+DNSCache resolves the hostnames the exporter connects to and keeps the result
+for ttl. When a resolution fails the expired addresses are used instead, so a
+flaky DNS server doesn't fail scrapes of a hub whose address hasn't changed,
+and a cached address which refuses connections triggers a new resolution.
+Entries of hosts not connected to for dnsIdleTimeout are evicted.
+*/
+type DNSCache struct {
+	ttl        time.Duration
+	resolver   *net.Resolver
+	dialer     *net.Dialer
+	anonymizer *Anonymizer // of the hosts and addresses in the metrics
+
+	mu      sync.Mutex
+	entries map[string]*dnsEntry
+
+	duration *prometheus.HistogramVec
+	address  *prometheus.Desc
+}
+
+func NewDNSCache(ttl time.Duration, anonymizer *Anonymizer) *DNSCache {
+	return &DNSCache{
+		ttl:        ttl,
+		resolver:   net.DefaultResolver,
+		dialer:     &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+		anonymizer: anonymizer,
+		entries:    map[string]*dnsEntry{},
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: ExporterSubsystem,
+			Name:      "dns_resolution_duration_seconds",
+			Help:      "Duration of DNS resolutions by result.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+		}, []string{"result"}),
+		address: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, ExporterSubsystem, "dns_resolved_address_info"),
+			"Address the exporter currently connects to for a host.",
+			[]string{"host", "address"}, nil),
+	}
+}
+
+// lookup returns the addresses of host and whether they came from the cache.
+func (c *DNSCache) lookup(ctx context.Context, host string) ([]string, bool, error) {
+	now := time.Now()
+	c.mu.Lock()
+	entry := c.entries[host]
+	var addrs []string
+	var expires time.Time
+	if entry != nil {
+		entry.used = now
+		addrs, expires = entry.addrs, entry.expires
+	}
+	c.mu.Unlock()
+	if entry != nil && now.Before(expires) {
+		return addrs, true, nil
+	}
+
+	resolved, err := c.resolve(ctx, host)
+	if err != nil {
+		if entry != nil {
+			logrus.Warnf("DNS resolution of %s failed, using cached addresses: %v", host, err)
+			return addrs, true, nil
+		}
+		return nil, false, err
+	}
+	return resolved, false, nil
+}
+
+func (c *DNSCache) resolve(ctx context.Context, host string) ([]string, error) {
+	start := time.Now()
+	addrs, err := c.resolver.LookupHost(ctx, host)
+	result := "success"
+	if err == nil && len(addrs) == 0 {
+		err = errors.New("no addresses found")
+	}
+	if err != nil {
+		result = "error"
+	}
+	c.duration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for h, e := range c.entries {
+		if now.Sub(e.used) > dnsIdleTimeout {
+			delete(c.entries, h)
+		}
+	}
+	entry := c.entries[host]
+	if entry == nil {
+		entry = &dnsEntry{}
+		c.entries[host] = entry
+	}
+	entry.addrs = addrs
+	entry.expires = now.Add(c.ttl)
+	entry.used = now
+	return addrs, nil
+}
+
+// DialContext is a drop-in replacement of the transport dialer.
+func (c *DNSCache) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) != nil {
+		return c.dialer.DialContext(ctx, network, address)
+	}
+
+	addrs, cached, err := c.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := c.dialAny(ctx, network, host, port, addrs)
+	if err != nil && cached {
+		logrus.Debugf("Connecting to cached addresses of %s failed, resolving again: %v", host, err)
+		if addrs, err = c.resolve(ctx, host); err != nil {
+			return nil, err
+		}
+		conn, err = c.dialAny(ctx, network, host, port, addrs)
+	}
+	return conn, err
+}
+
+func (c *DNSCache) dialAny(ctx context.Context, network, host, port string, addrs []string) (net.Conn, error) {
+	var err error
+	for _, addr := range addrs {
+		var conn net.Conn
+		if conn, err = c.dialer.DialContext(ctx, network, net.JoinHostPort(addr, port)); err == nil {
+			c.mu.Lock()
+			if entry := c.entries[host]; entry != nil {
+				entry.lastUsed = addr
+			}
+			c.mu.Unlock()
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+func (c *DNSCache) Describe(ch chan<- *prometheus.Desc) {
+	c.duration.Describe(ch)
+	ch <- c.address
+}
+
+func (c *DNSCache) Collect(ch chan<- prometheus.Metric) {
+	c.duration.Collect(ch)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for host, entry := range c.entries {
+		if entry.lastUsed != "" {
+			ch <- prometheus.MustNewConstMetric(c.address, prometheus.GaugeValue, 1, c.anonymizer.host(host), c.anonymizer.host(entry.lastUsed))
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package collector
+
+import "time"
+
+// Metric families whose freshness is exported, the values of the family
+// label.
+const (
+	familyGrid     = "grid"
+	familyNodes    = "nodes"
+	familySessions = "sessions"
+	familyQueue    = "queue"
+
+	familyLabel = "family"
+)
+
+/*
+markUpdated records that the given families were refreshed by snap at t. The
+map is shared with the previous snapshot, so it is copied instead of being
+updated in place.
+*/
+func (s *snapshot) markUpdated(t time.Time, families ...string) {
+	updated := make(map[string]time.Time, len(s.familyUpdated)+len(families))
+	for family, at := range s.familyUpdated {
+		updated[family] = at
+	}
+	for _, family := range families {
+		updated[family] = t
+	}
+	s.familyUpdated = updated
+}
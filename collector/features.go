@@ -0,0 +1,112 @@
+package collector
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// Debugging features of slots and sessions, the values of the feature label.
+const (
+	featureVNC   = "vnc"
+	featureVideo = "video"
+
+	featureLabel = "feature"
+)
+
+var features = []string{featureVNC, featureVideo}
+
+/*
+capabilityFlag is a boolean capability such as se:vncEnabled. Node
+configurations set it as string as often as as boolean, so both are accepted;
+anything else reads as false instead of failing the whole stereotype.
+*/
+type capabilityFlag bool
+
+func (f *capabilityFlag) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case bool:
+		*f = capabilityFlag(v)
+	case string:
+		b, _ := strconv.ParseBool(v)
+		*f = capabilityFlag(b)
+	default:
+		*f = false
+	}
+	return nil
+}
+
+// featureCapabilities are the capabilities of a slot stereotype or session
+// telling its debugging features.
+type featureCapabilities struct {
+	VNCEnabled  capabilityFlag `json:"se:vncEnabled"`
+	VNC         string         `json:"se:vnc"` // VNC URL of a running session
+	RecordVideo capabilityFlag `json:"se:recordVideo"`
+}
+
+func (c featureCapabilities) has(feature string) bool {
+	switch feature {
+	case featureVNC:
+		return bool(c.VNCEnabled) || c.VNC != ""
+	case featureVideo:
+		return bool(c.RecordVideo)
+	}
+	return false
+}
+
+/*
+fleetFeatures counts the slots and nodes supporting every feature, over the UP
+nodes which are not in maintenance like the capacity. A node supports a
+feature when one of its stereotypes does.
+*/
+func fleetFeatures(nodes []snapshotNode) (slots, nodeCount map[string]float64) {
+	slots = map[string]float64{}
+	nodeCount = map[string]float64{}
+	for _, f := range features {
+		slots[f], nodeCount[f] = 0, 0
+	}
+
+	for _, n := range nodes {
+		if n.Status != "UP" || n.maintenance {
+			continue
+		}
+		supported := map[string]bool{}
+		for _, s := range n.stereotypes {
+			caps := featureCapabilities{VNCEnabled: s.Stereotype.VNCEnabled, RecordVideo: s.Stereotype.RecordVideo}
+			for _, f := range features {
+				if caps.has(f) {
+					slots[f] += float64(s.Slots)
+					supported[f] = true
+				}
+			}
+		}
+		for f := range supported {
+			nodeCount[f]++
+		}
+	}
+	return slots, nodeCount
+}
+
+// sessionFeatures counts the running sessions using every feature according
+// to their capabilities.
+func sessionFeatures(sessions []hubSession) map[string]float64 {
+	counts := map[string]float64{}
+	for _, f := range features {
+		counts[f] = 0
+	}
+	for _, s := range sessions {
+		var caps featureCapabilities
+		if err := json.Unmarshal([]byte(s.Capabilities), &caps); err != nil {
+			continue
+		}
+		for _, f := range features {
+			if caps.has(f) {
+				counts[f]++
+			}
+		}
+	}
+	return counts
+}
@@ -0,0 +1,193 @@
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// APIGrid3 scrapes the legacy Selenium Grid 3 hub API.
+const APIGrid3 = "grid3"
+
+// grid3ProxyConcurrency is the number of /grid/api/proxy requests of a scrape
+// in flight at once.
+const grid3ProxyConcurrency = 4
+
+type grid3HubResponse struct {
+	Success                bool    `json:"success"`
+	MaxSession             float64 `json:"maxSession"`
+	NewSessionRequestCount float64 `json:"newSessionRequestCount"`
+	SlotCounts             struct {
+		Free  float64 `json:"free"`
+		Total float64 `json:"total"`
+	} `json:"slotCounts"`
+}
+
+type grid3ProxyResponse struct {
+	Success bool   `json:"success"`
+	Id      string `json:"id"`
+	Request struct {
+		Configuration struct {
+			MaxSession   float64 `json:"maxSession"`
+			Capabilities []struct {
+				BrowserName  string `json:"browserName"`
+				MaxInstances int    `json:"maxInstances"`
+				Platform     string `json:"platform"`
+				PlatformName string `json:"platformName"`
+				Version      string `json:"version"`
+			} `json:"capabilities"`
+		} `json:"configuration"`
+	} `json:"request"`
+}
+
+// grid3ConsoleProxy is a node as listed on the hub console page.
+type grid3ConsoleProxy struct {
+	id, os, version string
+	busy            float64
+}
+
+var (
+	grid3ProxyPattern   = regexp.MustCompile(`<div class=['"]proxy['"]>`)
+	grid3IdPattern      = regexp.MustCompile(`id : ([^,<\s]+)(?:, OS : ([^<]+))?`)
+	grid3VersionPattern = regexp.MustCompile(`\(version : ([^)]+)\)`)
+	grid3BusyPattern    = regexp.MustCompile(`class=['"]busy['"]`)
+	grid3ConsolePattern = regexp.MustCompile(`Grid Console v\.([0-9][0-9.]*)`)
+)
+
+/*
+fetchGrid3 scrapes a Selenium Grid 3 hub and maps it onto the Grid 4 data
+model. The hub API only reports slot totals, so the nodes are listed from the
+console page, the only place Grid 3 lists them, and their configuration is
+completed from /grid/api/proxy. All requests share the scrape timeout. It
+returns nil when the hub cannot be scraped.
+*/
+func (e *Collector) fetchGrid3(snap *snapshot) *hubResponse {
+	ctx, cancel := e.fetchContext()
+	defer cancel()
+
+	body, err := e.fetchWithin(ctx, FetchRequest{Path: "/grid/api/hub"})
+	if err != nil {
+		e.failFetch(snap, err)
+		return nil
+	}
+	if e.isHTML(snap, body) {
+		return nil
+	}
+	var hub grid3HubResponse
+	if err := json.Unmarshal(body, &hub); err != nil {
+		e.failDecode(snap, err)
+		return nil
+	}
+
+	hResponse := &hubResponse{}
+	grid := &hResponse.Data.Grid
+	grid.TotalSlots = hub.SlotCounts.Total
+	grid.SessionCount = hub.SlotCounts.Total - hub.SlotCounts.Free
+	grid.SessionQueueSize = &hub.NewSessionRequestCount
+
+	console, err := e.fetchWithin(ctx, FetchRequest{Path: "/grid/console"})
+	if err != nil {
+		logrus.Warnf("Failed to list the nodes of Grid 3 hub %s: %v", e.Name, err)
+		grid.MaxSession = grid.TotalSlots
+		return hResponse
+	}
+	if m := grid3ConsolePattern.FindSubmatch(console); m != nil {
+		grid.Version = string(m[1])
+	}
+
+	proxies := parseGrid3Console(string(console))
+	bodies := make([][]byte, len(proxies))
+	errs := make([]error, len(proxies))
+	slots := make(chan struct{}, grid3ProxyConcurrency)
+	var wg sync.WaitGroup
+	for i, p := range proxies {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, id string) {
+			defer func() { <-slots; wg.Done() }()
+			bodies[i], errs[i] = e.fetchWithin(ctx, FetchRequest{Path: "/grid/api/proxy?id=" + url.QueryEscape(id)})
+		}(i, p.id)
+	}
+	wg.Wait()
+
+	for i, p := range proxies {
+		node := e.grid3Node(snap, p, bodies[i], errs[i])
+		grid.MaxSession += node.MaxSession
+		hResponse.Data.NodesInfo.Nodes = append(hResponse.Data.NodesInfo.Nodes, node)
+	}
+	grid.NodeCount = float64(len(hResponse.Data.NodesInfo.Nodes))
+	return hResponse
+}
+
+// parseGrid3Console extracts the nodes and their busy slots from the console
+// page, one <div class='proxy'> per node.
+func parseGrid3Console(page string) []grid3ConsoleProxy {
+	var proxies []grid3ConsoleProxy
+	for _, chunk := range grid3ProxyPattern.Split(page, -1)[1:] {
+		m := grid3IdPattern.FindStringSubmatch(chunk)
+		if m == nil {
+			continue
+		}
+		p := grid3ConsoleProxy{id: m[1], os: strings.TrimSpace(m[2])}
+		if v := grid3VersionPattern.FindStringSubmatch(chunk); v != nil {
+			p.version = v[1]
+		}
+		p.busy = float64(len(grid3BusyPattern.FindAllStringIndex(chunk, -1)))
+		proxies = append(proxies, p)
+	}
+	return proxies
+}
+
+// grid3Node completes a console node with its configuration, the answer of
+// /grid/api/proxy. A node the hub no longer knows is reported DOWN.
+func (e *Collector) grid3Node(snap *snapshot, p grid3ConsoleProxy, body []byte, err error) HubResponseNode {
+	node := HubResponseNode{
+		Id:           p.id,
+		Uri:          p.id,
+		Status:       "UP",
+		SessionCount: p.busy,
+		Version:      p.version,
+		Stereotypes:  "[]",
+	}
+	node.OsInfo.Name = p.os
+
+	var proxy grid3ProxyResponse
+	if err == nil && e.isHTML(snap, body) {
+		err = errors.New("HTML page instead of JSON")
+	}
+	if err == nil {
+		err = json.Unmarshal(body, &proxy)
+	}
+	if err != nil || !proxy.Success {
+		logrus.Debugf("Failed to fetch Grid 3 proxy %s: %v", p.id, err)
+		node.Status = "DOWN"
+		return node
+	}
+
+	var stereotypes []Stereotype
+	for _, c := range proxy.Request.Configuration.Capabilities {
+		s := Stereotype{Slots: c.MaxInstances}
+		s.Stereotype.BrowserName = c.BrowserName
+		s.Stereotype.BrowserVersion = c.Version
+		s.Stereotype.PlatformName = c.PlatformName
+		if s.Stereotype.PlatformName == "" {
+			s.Stereotype.PlatformName = c.Platform
+		}
+		stereotypes = append(stereotypes, s)
+		node.SlotCount += float64(c.MaxInstances)
+	}
+	if encoded, err := json.Marshal(stereotypes); err == nil {
+		node.Stereotypes = string(encoded)
+	}
+
+	node.MaxSession = proxy.Request.Configuration.MaxSession
+	if node.MaxSession == 0 {
+		node.MaxSession = node.SlotCount
+	}
+	return node
+}
@@ -0,0 +1,159 @@
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// introspectionQuery asks for the fields of the types the query is built from.
+const introspectionQuery = `{"query": "{ ` +
+	`grid: __type(name: \"Grid\") { fields { name } }, ` +
+	`node: __type(name: \"Node\") { fields { name } }, ` +
+	`sessionsInfo: __type(name: \"SessionsInfo\") { fields { name } }, ` +
+	`session: __type(name: \"Session\") { fields { name } } }"}`
+
+// GraphQL types of the Grid schema, as named in the introspection response.
+const (
+	typeGrid         = "grid"
+	typeNode         = "node"
+	typeSessionsInfo = "sessionsInfo"
+	typeSession      = "session"
+)
+
+/*
+graphQLSchema is the cached result of the introspection of a Grid: the fields
+of every type the query is built from. Without types, introspection is not
+available and the full query is sent. The schema is kept until the Grid
+reports another version.
+*/
+type graphQLSchema struct {
+	version string // Grid version the schema was introspected from
+	types   map[string]map[string]bool
+}
+
+// has reports whether the field is known, assuming it is without schema.
+func (s *graphQLSchema) has(typ, field string) bool {
+	if s == nil || s.types == nil {
+		return true
+	}
+	fields, ok := s.types[typ]
+	return !ok || fields[field]
+}
+
+// fields returns the supported ones of the given fields of typ.
+func (s *graphQLSchema) fields(typ string, names ...string) []string {
+	supported := make([]string, 0, len(names))
+	for _, name := range names {
+		if s.has(typ, name) {
+			supported = append(supported, name)
+		}
+	}
+	return supported
+}
+
+// customFieldType returns the type holding the fields of a custom field
+// object.
+func customFieldType(object string) string {
+	switch object {
+	case CustomObjectNodes:
+		return typeNode
+	case CustomObjectSessions:
+		return typeSession
+	}
+	return typeGrid
+}
+
+/*
+currentSchema returns the cached schema, introspecting the Grid when there is
+none. A Grid which answers but rejects the introspection is cached as not
+supporting it; when it can't be reached the introspection is retried on the
+next scrape.
+*/
+func (e *Collector) currentSchema() *graphQLSchema {
+	if !e.introspect {
+		return nil
+	}
+	e.mu.Lock()
+	schema := e.schema
+	e.mu.Unlock()
+	if schema != nil {
+		return schema
+	}
+
+	body, err := e.fetch(graphQLRequest(introspectionQuery))
+	var statusErr *HTTPStatusError
+	if err != nil && !errors.As(err, &statusErr) {
+		return nil
+	}
+
+	schema = &graphQLSchema{}
+	if err == nil {
+		schema.types, err = decodeIntrospection(body)
+	}
+	if err != nil {
+		logrus.Warnf("GraphQL introspection of %s is not available, sending the full query: %v", e.Name, err)
+	} else {
+		logrus.Infof("Introspected the GraphQL schema of %s", e.Name)
+	}
+	for _, f := range e.customFields {
+		if name, _, _ := strings.Cut(f.Field, "."); !schema.has(customFieldType(f.Object), name) {
+			logrus.Warnf("Selenium Grid %s has no GraphQL field %s.%s, not querying %s", e.Name, f.Object, f.Field, f.Metric)
+		}
+	}
+
+	e.mu.Lock()
+	e.schema = schema
+	e.mu.Unlock()
+	return schema
+}
+
+func decodeIntrospection(body []byte) (map[string]map[string]bool, error) {
+	type introspectedType struct {
+		Fields []struct {
+			Name string `json:"name"`
+		} `json:"fields"`
+	}
+	var response struct {
+		Errors []graphQLError               `json:"errors"`
+		Data   map[string]*introspectedType `json:"data"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	if len(response.Errors) > 0 {
+		return nil, errors.New(response.Errors[0].Message)
+	}
+	if response.Data[typeGrid] == nil || len(response.Data[typeGrid].Fields) == 0 {
+		return nil, errors.New("no Grid type in the introspection response")
+	}
+
+	types := map[string]map[string]bool{}
+	for name, t := range response.Data {
+		if t == nil || len(t.Fields) == 0 {
+			continue
+		}
+		types[name] = map[string]bool{}
+		for _, f := range t.Fields {
+			types[name][f.Name] = true
+		}
+	}
+	return types, nil
+}
+
+// checkSchemaVersion drops the cached schema when the Grid reports another
+// version than the one it was introspected from, e.g. after an upgrade.
+func (e *Collector) checkSchemaVersion(version string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	switch {
+	case e.schema == nil || version == "":
+	case e.schema.version == "":
+		e.schema.version = version
+	case e.schema.version != version:
+		logrus.Infof("Selenium Grid %s changed from version %s to %s, introspecting its schema again", e.Name, e.schema.version, version)
+		e.schema = nil
+	}
+}
@@ -0,0 +1,129 @@
+package collector
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+type MaintenanceWindow struct {
+	Name  string    `yaml:"name" json:"name"`
+	Start time.Time `yaml:"start" json:"start"`
+	End   time.Time `yaml:"end" json:"end"`
+}
+
+func (w MaintenanceWindow) contains(t time.Time) bool {
+	return !t.Before(w.Start) && t.Before(w.End)
+}
+
+/*
+MaintenanceSchedule tracks the Grid maintenance windows declared in the config
+file or registered through the admin API. Raw Grid data keeps being collected
+during a window; the derived series alerts are built on (utilization ratios,
+backlog, orphaned sessions and anomalies) are left out.
+*/
+type MaintenanceSchedule struct {
+	mu      sync.Mutex
+	windows []MaintenanceWindow
+}
+
+func NewMaintenanceSchedule(windows []MaintenanceWindow) *MaintenanceSchedule {
+	return &MaintenanceSchedule{windows: windows}
+}
+
+// active reports whether a maintenance window covers the given time.
+func (m *MaintenanceSchedule) active(t time.Time) bool {
+	if m == nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, w := range m.windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MaintenanceSchedule) add(w MaintenanceWindow) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.windows = append(m.windows, w)
+}
+
+// end closes every window which is currently active.
+func (m *MaintenanceSchedule) end(t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, w := range m.windows {
+		if w.contains(t) {
+			m.windows[i].End = t
+		}
+	}
+}
+
+// list returns the windows which have not ended yet.
+func (m *MaintenanceSchedule) list(t time.Time) []MaintenanceWindow {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	windows := []MaintenanceWindow{}
+	for _, w := range m.windows {
+		if t.Before(w.End) {
+			windows = append(windows, w)
+		}
+	}
+	return windows
+}
+
+func (m *MaintenanceSchedule) Collector() prometheus.Collector {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: ExporterSubsystem,
+		Name:      "maintenance_active",
+		Help:      "Whether a Grid maintenance window is currently active.",
+	}, func() float64 {
+		return boolToFloat(m.active(time.Now()))
+	})
+}
+
+/*
+ServeHTTP implements the maintenance admin API:
+
+	GET    lists current and upcoming windows
+	POST   starts a window now, e.g. ?duration=2h&name=upgrade
+	DELETE ends all active windows
+*/
+func (m *MaintenanceSchedule) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		duration, err := time.ParseDuration(r.URL.Query().Get("duration"))
+		if err != nil || duration <= 0 {
+			http.Error(w, "a positive duration parameter is required", http.StatusBadRequest)
+			return
+		}
+		window := MaintenanceWindow{Name: r.URL.Query().Get("name"), Start: now, End: now.Add(duration)}
+		m.add(window)
+		logrus.Infof("Maintenance window %q started, ends at %s", window.Name, window.End.Format(time.RFC3339))
+	case http.MethodDelete:
+		m.end(now)
+		logrus.Info("Active maintenance windows ended")
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Active  bool                `json:"active"`
+		Windows []MaintenanceWindow `json:"windows"`
+	}{m.active(now), m.list(now)})
+}
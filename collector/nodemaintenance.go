@@ -0,0 +1,136 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// NodeMaintenanceEntry marks the nodes whose URI matches Pattern as being in
+// maintenance, until the given time or until it is removed when Until is unset.
+type NodeMaintenanceEntry struct {
+	Pattern string     `yaml:"pattern" json:"pattern"`
+	Reason  string     `yaml:"reason" json:"reason,omitempty"`
+	Until   *time.Time `yaml:"until" json:"until,omitempty"`
+
+	re *regexp.Regexp
+}
+
+func (n *NodeMaintenanceEntry) Compile() error {
+	re, err := regexp.Compile("^(?:" + n.Pattern + ")$")
+	if err != nil {
+		return fmt.Errorf("invalid node maintenance pattern %q: %w", n.Pattern, err)
+	}
+	n.re = re
+	return nil
+}
+
+func (n *NodeMaintenanceEntry) expired(t time.Time) bool {
+	return n.Until != nil && !t.Before(*n.Until)
+}
+
+/*
+NodeMaintenance tracks the nodes under planned maintenance. They keep being
+exported with selenium_node_maintenance set to 1, but are left out of derived
+capacity and alert metrics.
+*/
+type NodeMaintenance struct {
+	mu      sync.Mutex
+	entries []NodeMaintenanceEntry
+}
+
+func NewNodeMaintenance(entries []NodeMaintenanceEntry) *NodeMaintenance {
+	return &NodeMaintenance{entries: entries}
+}
+
+// matches reports whether the node with the given URI is in maintenance.
+func (m *NodeMaintenance) matches(uri string, t time.Time) bool {
+	if m == nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, entry := range m.entries {
+		if !entry.expired(t) && entry.re.MatchString(uri) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *NodeMaintenance) list(t time.Time) []NodeMaintenanceEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries := []NodeMaintenanceEntry{}
+	for _, entry := range m.entries {
+		if !entry.expired(t) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+/*
+ServeHTTP implements the node maintenance admin API:
+
+	GET    lists the active entries
+	POST   adds an entry, e.g. ?pattern=http://10\.0\.1\..*&reason=patching&duration=4h
+	DELETE removes the entry with the given pattern, or all entries without one
+*/
+func (m *NodeMaintenance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	query := r.URL.Query()
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		entry := NodeMaintenanceEntry{Pattern: query.Get("pattern"), Reason: query.Get("reason")}
+		if entry.Pattern == "" {
+			http.Error(w, "a pattern parameter is required", http.StatusBadRequest)
+			return
+		}
+		if err := entry.Compile(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if d := query.Get("duration"); d != "" {
+			duration, err := time.ParseDuration(d)
+			if err != nil || duration <= 0 {
+				http.Error(w, "duration must be a positive duration", http.StatusBadRequest)
+				return
+			}
+			until := now.Add(duration)
+			entry.Until = &until
+		}
+		m.mu.Lock()
+		m.entries = append(m.entries, entry)
+		m.mu.Unlock()
+		logrus.Infof("Nodes matching %q are in maintenance: %s", entry.Pattern, entry.Reason)
+	case http.MethodDelete:
+		pattern := query.Get("pattern")
+		m.mu.Lock()
+		kept := m.entries[:0]
+		for _, entry := range m.entries {
+			if pattern != "" && entry.Pattern != pattern {
+				kept = append(kept, entry)
+			}
+		}
+		m.entries = kept
+		m.mu.Unlock()
+		logrus.Infof("Node maintenance removed (pattern %q)", pattern)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Nodes []NodeMaintenanceEntry `json:"nodes"`
+	}{m.list(now)})
+}
@@ -0,0 +1,403 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+type nodeTarget struct {
+	Id  string
+	Uri string
+}
+
+type nodeStatusResult struct {
+	target   nodeTarget
+	up       bool
+	ready    bool
+	duration time.Duration
+	polledAt time.Time
+	sessions map[string]bool
+	failures int // consecutive failed polls
+
+	heartbeatPeriod time.Duration
+	sessionTimeout  time.Duration
+}
+
+type nodeStatusResponse struct {
+	Value struct {
+		Ready   bool   `json:"ready"`
+		Message string `json:"message"`
+		Node    struct {
+			HeartbeatPeriod float64 `json:"heartbeatPeriod"` // milliseconds
+			SessionTimeout  float64 `json:"sessionTimeout"`  // milliseconds
+			Slots           []struct {
+				Session *struct {
+					SessionId string `json:"sessionId"`
+				} `json:"session"`
+			} `json:"slots"`
+		} `json:"node"`
+	} `json:"value"`
+}
+
+/*
+nodeStatusScheduler polls the /status endpoint of every node known to the
+Grid. Requests are spread evenly over the interval instead of being sent in a
+burst, and never exceed the configured per-second budget.
+*/
+type nodeStatusScheduler struct {
+	client   *http.Client
+	interval time.Duration
+	rate     float64
+
+	// perNode enables the per-node series, top the ranked series of the
+	// nodes failing the most.
+	perNode bool
+	top     int
+	// publishFailures counts the collections leaving out metrics.
+	publishFailures prometheus.Counter
+	anonymizer      *Anonymizer
+
+	mu      sync.Mutex
+	nodes   []nodeTarget
+	known   map[string]bool // ids of nodes
+	polling map[string]bool // ids of nodes with a poll in flight
+	results map[string]nodeStatusResult
+
+	nodeUp, nodeReady, nodeDuration         *prometheus.Desc
+	nodeHeartbeatPeriod, nodeSessionTimeout *prometheus.Desc
+	nodeTopFailures                         *prometheus.Desc
+	lag, maxLag, roundDuration              prometheus.Gauge
+	requests                                *prometheus.CounterVec
+}
+
+func newNodeStatusScheduler(client *http.Client, interval time.Duration, rate float64, labels prometheus.Labels) *nodeStatusScheduler {
+	return &nodeStatusScheduler{
+		client:   client,
+		interval: interval,
+		rate:     rate,
+		perNode:  true,
+		polling:  map[string]bool{},
+		results:  map[string]nodeStatusResult{},
+		nodeTopFailures: newTopNodesDesc("top_status_failures",
+			"Number of consecutive failed /status polls of the nodes failing the most, ranked from 1.", labels),
+		nodeUp: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, nodeSubsystem, "status_up"),
+			"Was the last request to the node /status endpoint successful.",
+			[]string{nodeIdLabel, nodeUriLabel}, labels),
+		nodeReady: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, nodeSubsystem, "ready"),
+			"Node readiness as reported by its /status endpoint.",
+			[]string{nodeIdLabel, nodeUriLabel}, labels),
+		nodeDuration: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, nodeSubsystem, "status_duration_seconds"),
+			"Duration of the last request to the node /status endpoint.",
+			[]string{nodeIdLabel, nodeUriLabel}, labels),
+		nodeHeartbeatPeriod: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, nodeSubsystem, "heartbeat_period_seconds"),
+			"Interval at which the node sends heartbeats to the Grid.",
+			[]string{nodeIdLabel, nodeUriLabel}, labels),
+		nodeSessionTimeout: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, nodeSubsystem, "session_timeout_seconds"),
+			"Time after which the node kills sessions without activity.",
+			[]string{nodeIdLabel, nodeUriLabel}, labels),
+		lag: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   Namespace,
+			Subsystem:   ExporterSubsystem,
+			Name:        "node_status_scheduler_lag_seconds",
+			Help:        "Delay between the planned and actual dispatch time of the last node /status request.",
+			ConstLabels: labels,
+		}),
+		maxLag: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   Namespace,
+			Subsystem:   ExporterSubsystem,
+			Name:        "node_status_scheduler_max_lag_seconds",
+			Help:        "Largest dispatch delay observed during the last completed scheduling round.",
+			ConstLabels: labels,
+		}),
+		roundDuration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   Namespace,
+			Subsystem:   ExporterSubsystem,
+			Name:        "node_status_scheduler_round_duration_seconds",
+			Help:        "Time it took to dispatch requests to all nodes during the last completed round.",
+			ConstLabels: labels,
+		}),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   Namespace,
+			Subsystem:   ExporterSubsystem,
+			Name:        "node_status_requests_total",
+			Help:        "Number of node /status requests by result: success, error, or skipped as the previous request to the node is still running.",
+			ConstLabels: labels,
+		}, []string{"result"}),
+	}
+}
+
+// setNodes replaces the list of nodes polled from the next round on.
+func (s *nodeStatusScheduler) setNodes(nodes []nodeTarget) {
+	known := make(map[string]bool, len(nodes))
+	for _, n := range nodes {
+		known[n.Id] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nodes = nodes
+	s.known = known
+}
+
+// spacing returns the delay between two consecutive requests in a round.
+func (s *nodeStatusScheduler) spacing(count int) time.Duration {
+	if count == 0 {
+		return s.interval
+	}
+	spacing := s.interval / time.Duration(count)
+	if s.rate > 0 {
+		if minSpacing := time.Duration(float64(time.Second) / s.rate); spacing < minSpacing {
+			spacing = minSpacing
+		}
+	}
+	return spacing
+}
+
+func (s *nodeStatusScheduler) run(ctx context.Context) {
+	for {
+		s.mu.Lock()
+		nodes := s.nodes
+		s.mu.Unlock()
+
+		start := time.Now()
+		s.prune(nodes)
+		if len(nodes) == 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(s.interval):
+			}
+			continue
+		}
+
+		spacing := s.spacing(len(nodes))
+		var maxLag time.Duration
+		for i, n := range nodes {
+			planned := start.Add(time.Duration(i) * spacing)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Until(planned)):
+			}
+
+			lag := time.Since(planned)
+			if lag > maxLag {
+				maxLag = lag
+			}
+			s.lag.Set(lag.Seconds())
+			if !s.startPoll(n.Id) {
+				// A node slower to answer than the interval gets one request
+				// at a time rather than one more goroutine every round.
+				logrus.Debugf("Skipping status of node %s, the previous request is still running", n.Id)
+				s.requests.WithLabelValues("skipped").Inc()
+				continue
+			}
+			go s.poll(ctx, n)
+		}
+		s.maxLag.Set(maxLag.Seconds())
+		s.roundDuration.Set(time.Since(start).Seconds())
+
+		// Wait for the remainder of the interval before starting the next round.
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(start.Add(time.Duration(len(nodes)) * spacing))):
+		}
+	}
+}
+
+// prune drops results of nodes which are no longer part of the Grid.
+func (s *nodeStatusScheduler) prune(nodes []nodeTarget) {
+	known := make(map[string]bool, len(nodes))
+	for _, n := range nodes {
+		known[n.Id] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id := range s.results {
+		if !known[id] {
+			delete(s.results, id)
+		}
+	}
+}
+
+/*
+orphanedSessions counts sessions the hub reports which no node claims. A
+session only counts once its node has been polled after the session started,
+or when the node it was assigned to has left the Grid altogether.
+*/
+func (s *nodeStatusScheduler) orphanedSessions(sessions []hubSession, now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	orphaned := 0
+	for _, session := range sessions {
+		if !s.known[session.NodeId] {
+			orphaned++
+			continue
+		}
+		r, polled := s.results[session.NodeId]
+		if !polled || !r.up || r.polledAt.Before(session.startedAt(now)) {
+			continue
+		}
+		if !r.sessions[session.Id] {
+			orphaned++
+		}
+	}
+	return orphaned
+}
+
+// startPoll marks the node as polled, unless its previous poll is still in
+// flight.
+func (s *nodeStatusScheduler) startPoll(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.polling[id] {
+		return false
+	}
+	s.polling[id] = true
+	return true
+}
+
+func (s *nodeStatusScheduler) poll(ctx context.Context, n nodeTarget) {
+	start := time.Now()
+	result := nodeStatusResult{target: n, polledAt: start}
+
+	status, err := s.fetch(ctx, n.Uri)
+	result.duration = time.Since(start)
+	if err != nil {
+		logrus.Debugf("Error fetching status of node %s: %v", n.Id, err)
+		s.requests.WithLabelValues("error").Inc()
+	} else {
+		result.up = true
+		result.ready = status.Value.Ready
+		result.heartbeatPeriod = time.Duration(status.Value.Node.HeartbeatPeriod * float64(time.Millisecond))
+		result.sessionTimeout = time.Duration(status.Value.Node.SessionTimeout * float64(time.Millisecond))
+		result.sessions = map[string]bool{}
+		for _, slot := range status.Value.Node.Slots {
+			if slot.Session != nil {
+				result.sessions[slot.Session.SessionId] = true
+			}
+		}
+		s.requests.WithLabelValues("success").Inc()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.polling, n.Id)
+	if !s.known[n.Id] {
+		// The node left the Grid while it was polled.
+		return
+	}
+	if !result.up {
+		result.failures = s.results[n.Id].failures + 1
+	}
+	s.results[n.Id] = result
+}
+
+func (s *nodeStatusScheduler) fetch(ctx context.Context, uri string) (*nodeStatusResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", uri+"/status", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var status nodeStatusResponse
+	if err := json.Unmarshal(body, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (s *nodeStatusScheduler) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.nodeUp
+	ch <- s.nodeReady
+	ch <- s.nodeDuration
+	ch <- s.nodeHeartbeatPeriod
+	ch <- s.nodeSessionTimeout
+	ch <- s.nodeTopFailures
+	s.lag.Describe(ch)
+	s.maxLag.Describe(ch)
+	s.roundDuration.Describe(ch)
+	s.requests.Describe(ch)
+}
+
+func (s *nodeStatusScheduler) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	results := make([]nodeStatusResult, 0, len(s.results))
+	for _, r := range s.results {
+		results = append(results, r)
+	}
+	s.mu.Unlock()
+
+	var errs publishErrors
+	gauge := errs.gauge(ch)
+	if s.top > 0 {
+		failing := make([]rankedNode, 0, len(results))
+		for _, r := range results {
+			failing = append(failing, rankedNode{r.target, float64(r.failures)})
+		}
+		collectTopNodes(gauge, s.anonymizer, s.nodeTopFailures, failing, s.top)
+	}
+
+	if s.perNode {
+		s.collectNodes(gauge, results)
+	}
+	reportPublish(&errs, "node status", s.publishFailures)
+	ch <- s.lag
+	ch <- s.maxLag
+	ch <- s.roundDuration
+	s.requests.Collect(ch)
+}
+
+func (s *nodeStatusScheduler) collectNodes(gauge func(*prometheus.Desc, float64, ...string), results []nodeStatusResult) {
+	for _, r := range results {
+		labels := []string{r.target.Id, s.anonymizer.uri(r.target.Uri)}
+		gauge(s.nodeUp, boolToFloat(r.up), labels...)
+		if r.up {
+			gauge(s.nodeReady, boolToFloat(r.ready), labels...)
+			if r.heartbeatPeriod > 0 {
+				gauge(s.nodeHeartbeatPeriod, r.heartbeatPeriod.Seconds(), labels...)
+			}
+			if r.sessionTimeout > 0 {
+				gauge(s.nodeSessionTimeout, r.sessionTimeout.Seconds(), labels...)
+			}
+		}
+		gauge(s.nodeDuration, r.duration.Seconds(), labels...)
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1.0
+	}
+	return 0.0
+}
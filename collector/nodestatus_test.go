@@ -0,0 +1,42 @@
+package collector
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestNodeStatusSkipsPollsInFlight(t *testing.T) {
+	var requests atomic.Int32
+	release := make(chan struct{})
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		w.Write([]byte(`{"value": {"ready": true}}`))
+	}))
+	defer node.Close()
+	defer close(release)
+
+	s := newNodeStatusScheduler(node.Client(), 10*time.Millisecond, 0, prometheus.Labels{GridLabel: "test"})
+	s.setNodes([]nodeTarget{{Id: "node", Uri: node.URL}})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.run(ctx)
+
+	time.Sleep(200 * time.Millisecond)
+	if n := requests.Load(); n != 1 {
+		t.Errorf("got %d requests to a node which did not answer yet, want 1", n)
+	}
+	if n := counterValue(t, s.requests.WithLabelValues("skipped")); n == 0 {
+		t.Error("no poll was skipped")
+	}
+}
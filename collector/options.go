@@ -0,0 +1,334 @@
+package collector
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultTimeout bounds the requests of a collector without a Timeout.
+const DefaultTimeout = 5 * time.Second
+
+// DefaultNodeStatusInterval is the interval of node status scraping without
+// an Interval, DefaultComponentInterval the one of the components.
+const (
+	DefaultNodeStatusInterval = 30 * time.Second
+	DefaultComponentInterval  = 15 * time.Second
+)
+
+// Options configure a Collector. The zero value of every field but URI is a
+// usable default.
+type Options struct {
+	// Name is the value of the grid label, URI the base URL of the Grid.
+	Name, URI string
+	// Labels are added to every metric besides grid. Collectors registered
+	// in the same registry must use the same label names.
+	Labels map[string]string
+	// InstanceID identifies the exporter process in the JSON APIs, so
+	// consumers can tell replicas apart.
+	InstanceID string
+	// Anonymizer replaces the hostnames and URIs in the metrics and the
+	// status by pseudonyms; Name and Labels are used as given.
+	Anonymizer *Anonymizer
+
+	// Username and Password authenticate the requests to the Grid with
+	// HTTP basic authentication; nodes and components are not sent them.
+	Username, Password string
+	// TLS configures the connections to the Grid, its nodes and components.
+	// It is ignored when Transport is set.
+	TLS *tls.Config
+	// Transport replaces the default HTTP transport.
+	Transport http.RoundTripper
+	// Outbound bounds the concurrency of the requests, possibly shared by
+	// several collectors.
+	Outbound *OutboundManager
+	// Fetcher replaces the HTTP requests to the Grid, the first stage of a
+	// scrape; nodes, components and the session probe are still sent over
+	// HTTP.
+	Fetcher Fetcher
+
+	// Timeout bounds a scrape including its retries, DefaultTimeout when
+	// zero. With AdaptiveTimeout it is the maximum.
+	Timeout         time.Duration
+	AdaptiveTimeout *AdaptiveTimeoutOptions
+	// Retries is the number of additional attempts after a transient
+	// failure, waiting RetryBackoff (doubled every attempt) in between.
+	Retries      int
+	RetryBackoff time.Duration
+
+	// API is the Grid API to scrape, APIGraphQL when empty.
+	API string
+	// ScrapeInterval scrapes in the background instead of on every Collect.
+	ScrapeInterval time.Duration
+	// Context stops the background loops once done; they run for the life
+	// of the process when nil.
+	Context context.Context
+
+	// SkipNodes, SkipSessions and SkipQueue leave the respective sub-queries
+	// out of the GraphQL query.
+	SkipNodes, SkipSessions, SkipQueue bool
+	// Introspect leaves the fields the GraphQL schema of the Grid lacks out
+	// of the query, introspecting it again when the Grid version changes.
+	Introspect bool
+	// Scaler exports the per-browser demand and capacity.
+	Scaler bool
+	// SessionFeatures requests the session capabilities to count the
+	// sessions using VNC and video recording; Scaler requests them anyway.
+	SessionFeatures bool
+	// HoldAnomalous keeps the previous values for one scrape when the Grid
+	// reports physically impossible ones.
+	HoldAnomalous bool
+	// ServeStaleFor keeps serving the values of the last successful scrape,
+	// node series included, for this long after the Grid stops answering.
+	ServeStaleFor time.Duration
+	// SkipNodeMetrics leaves out the per-node series, NodeTop exports the
+	// given number of busiest nodes instead.
+	SkipNodeMetrics bool
+	NodeTop         int
+	// CustomFields are additional GraphQL fields exported as gauges; each
+	// must have been compiled. They are only scraped through GraphQL.
+	CustomFields []CustomField
+	// NodeMaintenance marks nodes as being in planned maintenance.
+	NodeMaintenance *NodeMaintenance
+	// Maintenance leaves out the derived series alerts are built on during
+	// the Grid maintenance windows; it may be shared by several collectors.
+	Maintenance *MaintenanceSchedule
+
+	// NodeStatus enables the scraping of the /status endpoint of the nodes.
+	NodeStatus *NodeStatusOptions
+	// SessionProbeInterval enables a new-session probe at this interval.
+	SessionProbeInterval time.Duration
+	// Components are the servers of a Grid running in distributed mode,
+	// polled every ComponentInterval, DefaultComponentInterval when zero.
+	Components        []Component
+	ComponentInterval time.Duration
+}
+
+// AdaptiveTimeoutOptions derive the scrape timeout from a latency percentile
+// of the recent requests times a multiplier, bounded by Min.
+type AdaptiveTimeoutOptions struct {
+	Percentile, Multiplier float64
+	Min                    time.Duration
+}
+
+// NodeStatusOptions spread the node /status requests over Interval,
+// DefaultNodeStatusInterval when zero, sending at most Rate requests per
+// second (0 for no limit).
+type NodeStatusOptions struct {
+	Interval time.Duration
+	Rate     float64
+}
+
+// NewCollector returns the collector of a single Grid and starts its
+// background loops, which run until opts.Context is done.
+func NewCollector(opts Options) *Collector {
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	transport := opts.Transport
+	if transport == nil {
+		base := http.DefaultTransport.(*http.Transport).Clone()
+		base.TLSClientConfig = opts.TLS
+		transport = base
+	}
+	gridTransport := transport
+	if opts.Username != "" || opts.Password != "" {
+		gridTransport = &basicAuthTransport{username: opts.Username, password: opts.Password, next: transport}
+	}
+	api := opts.API
+	if api == "" {
+		api = APIGraphQL
+	}
+	name := opts.Name
+	labels := prometheus.Labels{GridLabel: name}
+	for k, v := range opts.Labels {
+		labels[k] = v
+	}
+
+	e := newCollector(name, opts.URI, labels, opts.Outbound.client(destinationGrid, name, timeout, gridTransport))
+	e.instanceID = opts.InstanceID
+	e.retries = opts.Retries
+	e.retryBackoff = opts.RetryBackoff
+	if a := opts.AdaptiveTimeout; a != nil {
+		e.adaptiveTimeout = newAdaptiveTimeout(a.Percentile, a.Multiplier, a.Min, timeout, labels)
+	}
+	e.nodeMaintenance = opts.NodeMaintenance
+	e.maintenance = opts.Maintenance
+	e.api = api
+	e.holdAnomalous = opts.HoldAnomalous
+	e.serveStaleFor = opts.ServeStaleFor
+	e.scaler = opts.Scaler
+	e.sessionFeatures = opts.SessionFeatures
+	e.queryNodes = !opts.SkipNodes
+	e.querySessions = !opts.SkipSessions
+	e.queryQueue = !opts.SkipQueue
+	e.introspect = opts.Introspect
+	e.nodeMetrics = !opts.SkipNodeMetrics
+	e.nodeTop = opts.NodeTop
+	e.customFields = opts.CustomFields
+	e.anonymizer = opts.Anonymizer
+	e.parser = &gridParser{customFields: opts.CustomFields, anonymizer: opts.Anonymizer}
+	if opts.Fetcher != nil {
+		e.fetcher = opts.Fetcher
+	}
+	for _, f := range opts.CustomFields {
+		e.customDescs = append(e.customDescs, f.desc(labels))
+	}
+	if len(opts.CustomFields) > 0 && (api == APIStatus || api == APIGrid3) {
+		logrus.Warnf("Custom GraphQL fields are not scraped from %s through the %s API", name, api)
+	}
+
+	nodeStatus := opts.NodeStatus
+	if nodeStatus != nil && nodeStatus.Interval <= 0 {
+		nodeStatus = &NodeStatusOptions{Interval: DefaultNodeStatusInterval, Rate: nodeStatus.Rate}
+	}
+	if nodeStatus != nil && api == APIGrid3 {
+		logrus.Warnf("Node status scraping is not supported for Grid 3 hub %s", name)
+		nodeStatus = nil
+	}
+	if nodeStatus != nil && opts.SkipNodes {
+		logrus.Warnf("Node status scraping of %s has no nodes to poll without the nodes sub-query", name)
+	}
+	if nodeStatus != nil {
+		logrus.Infof("Node status scraping enabled for %s (interval %s, max %.1f req/s)", name, nodeStatus.Interval.String(), nodeStatus.Rate)
+		e.nodeScheduler = newNodeStatusScheduler(opts.Outbound.client(destinationNode, name, timeout, transport), nodeStatus.Interval, nodeStatus.Rate, labels)
+		e.nodeScheduler.perNode = e.nodeMetrics
+		e.nodeScheduler.top = e.nodeTop
+		e.nodeScheduler.publishFailures = e.stageErrors.WithLabelValues(stagePublish)
+		e.nodeScheduler.anonymizer = opts.Anonymizer
+		go e.nodeScheduler.run(ctx)
+	}
+
+	if opts.SessionProbeInterval > 0 {
+		logrus.Infof("New-session probe enabled for %s (interval %s)", name, opts.SessionProbeInterval.String())
+		e.probe = newSessionProbe(opts.URI, opts.Outbound.client(destinationProbe, name, timeout, gridTransport), opts.SessionProbeInterval, labels)
+		go e.probe.run(ctx)
+	}
+
+	if len(opts.Components) > 0 {
+		interval := opts.ComponentInterval
+		if interval <= 0 {
+			interval = DefaultComponentInterval
+		}
+		logrus.Infof("Polling %d components of %s (interval %s)", len(opts.Components), name, interval.String())
+		e.components = newComponentStatus(opts.Components, opts.Outbound.client(destinationComponent, name, timeout, transport), interval, labels)
+		e.components.publishFailures = e.stageErrors.WithLabelValues(stagePublish)
+		e.components.anonymizer = opts.Anonymizer
+		go e.components.run(ctx)
+	}
+
+	if opts.ScrapeInterval > 0 {
+		logrus.Infof("Scraping %s in the background every %s", name, opts.ScrapeInterval.String())
+		e.scrapeInterval = opts.ScrapeInterval
+		go e.runScrapeLoop(ctx)
+	}
+	return e
+}
+
+type basicAuthTransport struct {
+	username, password string
+	next               http.RoundTripper
+}
+
+func (t *basicAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.SetBasicAuth(t.username, t.password)
+	return t.next.RoundTrip(req)
+}
+
+// Status summarizes the state of a collector for humans, e.g. on a status
+// page.
+type Status struct {
+	// URI is the Grid URI without password, pseudonymized when
+	// anonymizing.
+	URI string
+	// Labels are the constant labels of the metrics, grid included.
+	Labels map[string]string
+	// Scraped is false until the first scrape finished, LastScrape is when
+	// the last one started.
+	Scraped    bool
+	Up         bool
+	LastScrape time.Time
+	// LastSuccess is zero before the first successful scrape, LastError
+	// empty before the first failed one.
+	LastSuccess, LastErrorAt time.Time
+	LastError                string
+	Duration                 time.Duration
+	// API is the configured API, UsedAPI the one of the last scrape.
+	API, UsedAPI string
+
+	Timeout            time.Duration
+	Retries            int
+	ScrapeInterval     time.Duration
+	NodeStatusInterval time.Duration // zero without node status scraping
+}
+
+// Status returns the state of the last scrape and the settings of e.
+func (e *Collector) Status() Status {
+	s := Status{
+		URI:            e.anonymizer.uri(RedactURI(e.URI)),
+		Labels:         make(map[string]string, len(e.labels)),
+		API:            e.api,
+		Timeout:        e.client.Timeout,
+		Retries:        e.retries,
+		ScrapeInterval: e.scrapeInterval,
+	}
+	for k, v := range e.labels {
+		s.Labels[k] = v
+	}
+	if e.nodeScheduler != nil {
+		s.NodeStatusInterval = e.nodeScheduler.interval
+	}
+
+	e.mu.Lock()
+	snap := e.last
+	e.mu.Unlock()
+	if snap == nil {
+		return s
+	}
+
+	s.Scraped = true
+	s.Up = snap.up
+	s.LastScrape = snap.at
+	s.LastSuccess = snap.lastSuccess
+	s.LastErrorAt = snap.lastErrorAt
+	s.LastError = e.anonymizer.message(snap.lastError, e.URI)
+	s.Duration = snap.duration
+	s.UsedAPI = snap.api
+	return s
+}
+
+/*
+Ready returns why e is not ready, or nil when its last successful scrape is at
+most maxAge old. In on-demand mode a stale collector scrapes first, so that
+readiness doesn't depend on how recently Prometheus scraped.
+*/
+func (e *Collector) Ready(maxAge time.Duration) error {
+	e.mu.Lock()
+	snap := e.last
+	e.mu.Unlock()
+	if e.scrapeInterval == 0 && (snap == nil || time.Since(snap.lastSuccess) > maxAge) {
+		snap = e.refresh()
+	}
+
+	switch {
+	case snap == nil:
+		return errors.New("not scraped yet")
+	case snap.lastSuccess.IsZero():
+		return fmt.Errorf("never scraped successfully: %s", e.anonymizer.message(snap.lastError, e.URI))
+	case time.Since(snap.lastSuccess) > maxAge:
+		return fmt.Errorf("last successful scrape %s ago: %s", time.Since(snap.lastSuccess).Round(time.Second), e.anonymizer.message(snap.lastError, e.URI))
+	}
+	return nil
+}
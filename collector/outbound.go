@@ -0,0 +1,225 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	destinationGrid      = "grid"
+	destinationNode      = "node"
+	destinationProbe     = "probe"
+	destinationComponent = "component"
+	// destinationReplication is not specific to a Grid.
+	destinationReplication = "replication"
+
+	rejectQueueFull = "queue_full"
+	rejectTimeout   = "timeout"
+)
+
+var (
+	errOutboundQueueFull = errors.New("outbound request queue is full")
+	errOutboundTimeout   = errors.New("timed out waiting for an outbound request slot")
+)
+
+/*
+OutboundManager bounds the requests the exporter sends to Selenium Grid. A
+global worker pool caps the total concurrency, every destination (grid
+scraping, node status scraping, probes of each Grid) has its own concurrency
+cap, and requests waiting too long or beyond the queue limit are rejected. A
+single misbehaving subsystem therefore cannot starve the others.
+*/
+type OutboundManager struct {
+	workers      chan struct{}
+	maxQueue     int
+	queueTimeout time.Duration
+	perDest      int
+
+	mu     sync.Mutex
+	queued int
+	dests  map[outboundDestination]chan struct{}
+
+	queueDepth, inFlight *prometheus.GaugeVec
+	rejected             *prometheus.CounterVec
+}
+
+// NewOutboundManager returns a manager with the given limits; limits below 1
+// would block every request and are raised to 1.
+func NewOutboundManager(maxConcurrency, maxPerDestination, maxQueue int, queueTimeout time.Duration) *OutboundManager {
+	maxConcurrency, maxPerDestination, maxQueue = atLeastOne(maxConcurrency), atLeastOne(maxPerDestination), atLeastOne(maxQueue)
+	return &OutboundManager{
+		workers:      make(chan struct{}, maxConcurrency),
+		maxQueue:     maxQueue,
+		queueTimeout: queueTimeout,
+		perDest:      maxPerDestination,
+		dests:        map[outboundDestination]chan struct{}{},
+		queueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: ExporterSubsystem,
+			Name:      "outbound_queue_depth",
+			Help:      "Number of outbound requests waiting for a free slot.",
+		}, []string{"destination", GridLabel}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: ExporterSubsystem,
+			Name:      "outbound_in_flight",
+			Help:      "Number of outbound requests currently in flight.",
+		}, []string{"destination", GridLabel}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: ExporterSubsystem,
+			Name:      "outbound_rejected_total",
+			Help:      "Number of outbound requests rejected by the request manager.",
+		}, []string{"destination", GridLabel, "reason"}),
+	}
+}
+
+// outboundDestination is a subsystem (grid, node, probe) of a single Grid.
+type outboundDestination struct {
+	subsystem, grid string
+}
+
+// client returns an HTTP client whose requests are accounted to the given
+// subsystem of a Grid, or a plain client for a nil manager.
+func (m *OutboundManager) client(subsystem, grid string, timeout time.Duration, next http.RoundTripper) *http.Client {
+	if m == nil {
+		return &http.Client{Timeout: timeout, Transport: next}
+	}
+	destination := outboundDestination{subsystem, grid}
+
+	m.mu.Lock()
+	if _, exists := m.dests[destination]; !exists {
+		m.dests[destination] = make(chan struct{}, m.perDest)
+		m.queueDepth.WithLabelValues(subsystem, grid)
+		m.inFlight.WithLabelValues(subsystem, grid)
+		m.rejected.WithLabelValues(subsystem, grid, rejectQueueFull)
+		m.rejected.WithLabelValues(subsystem, grid, rejectTimeout)
+	}
+	m.mu.Unlock()
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &limitedTransport{manager: m, destination: destination, next: next},
+	}
+}
+
+// Client returns an HTTP client for the requests of the exporter which don't
+// belong to a Grid, such as pushes to a metrics backend, accounted to
+// destination with an empty grid label, or a plain client for a nil manager.
+func (m *OutboundManager) Client(destination string, timeout time.Duration, next http.RoundTripper) *http.Client {
+	return m.client(destination, "", timeout, next)
+}
+
+// acquire waits for a destination slot and a global worker.
+func (m *OutboundManager) acquire(ctx context.Context, destination outboundDestination) (func(), error) {
+	m.mu.Lock()
+	if m.queued >= m.maxQueue {
+		m.mu.Unlock()
+		m.rejected.WithLabelValues(destination.subsystem, destination.grid, rejectQueueFull).Inc()
+		return nil, errOutboundQueueFull
+	}
+	m.queued++
+	slots := m.dests[destination]
+	m.mu.Unlock()
+
+	queueDepth := m.queueDepth.WithLabelValues(destination.subsystem, destination.grid)
+	queueDepth.Inc()
+	defer func() {
+		queueDepth.Dec()
+		m.mu.Lock()
+		m.queued--
+		m.mu.Unlock()
+	}()
+
+	timer := time.NewTimer(m.queueTimeout)
+	defer timer.Stop()
+
+	if err := m.wait(ctx, timer, slots, destination); err != nil {
+		return nil, err
+	}
+	if err := m.wait(ctx, timer, m.workers, destination); err != nil {
+		<-slots
+		return nil, err
+	}
+
+	inFlight := m.inFlight.WithLabelValues(destination.subsystem, destination.grid)
+	inFlight.Inc()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			inFlight.Dec()
+			<-m.workers
+			<-slots
+		})
+	}, nil
+}
+
+func (m *OutboundManager) wait(ctx context.Context, timer *time.Timer, sem chan struct{}, destination outboundDestination) error {
+	select {
+	case sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		m.rejected.WithLabelValues(destination.subsystem, destination.grid, rejectTimeout).Inc()
+		return errOutboundTimeout
+	}
+}
+
+func (m *OutboundManager) Describe(ch chan<- *prometheus.Desc) {
+	m.queueDepth.Describe(ch)
+	m.inFlight.Describe(ch)
+	m.rejected.Describe(ch)
+}
+
+func (m *OutboundManager) Collect(ch chan<- prometheus.Metric) {
+	m.queueDepth.Collect(ch)
+	m.inFlight.Collect(ch)
+	m.rejected.Collect(ch)
+}
+
+type limitedTransport struct {
+	manager     *OutboundManager
+	destination outboundDestination
+	next        http.RoundTripper
+}
+
+// RoundTrip holds the acquired slot until the response body is closed.
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	release, err := t.manager.acquire(req.Context(), t.destination)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
+
+func atLeastOne(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
@@ -0,0 +1,58 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"strings"
+	"testing"
+)
+
+// replayFetcher answers the requests of a scrape with recorded bodies by
+// path, and 404 for the other paths.
+type replayFetcher map[string][]byte
+
+func (f replayFetcher) Fetch(ctx context.Context, req FetchRequest) (io.ReadCloser, error) {
+	body, ok := f[req.Path]
+	if !ok {
+		return nil, &HTTPStatusError{Code: 404, Status: "404 Not Found"}
+	}
+	return io.NopCloser(bytes.NewReader(body)), nil
+}
+
+func TestDecodeNodesBogusCount(t *testing.T) {
+	var nodes []HubResponseNode
+	dec := json.NewDecoder(strings.NewReader(`[{"id": "node-1"}, {"id": "node-2"}]`))
+	if err := decodeNodes(dec, &nodes, math.MaxInt); err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 || cap(nodes) > maxNodesHint {
+		t.Errorf("got %d nodes in a slice of capacity %d", len(nodes), cap(nodes))
+	}
+}
+
+func BenchmarkDecodeNodes(b *testing.B) {
+	var response struct {
+		Data struct {
+			NodesInfo struct {
+				Nodes json.RawMessage `json:"nodes"`
+			} `json:"nodesInfo"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(gridResponse(2000), &response); err != nil {
+		b.Fatal(err)
+	}
+	payload := response.Data.NodesInfo.Nodes
+
+	b.SetBytes(int64(len(payload)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var nodes []HubResponseNode
+		if err := decodeNodes(json.NewDecoder(bytes.NewReader(payload)), &nodes, 2000); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package collector
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+)
+
+// replicatedSnapshot is the wire format of the latest snapshot of a target and
+// its counters, pulled by a peer replica on startup.
+type replicatedSnapshot struct {
+	Grid            string               `json:"grid"`
+	InstanceID      string               `json:"instanceId,omitempty"`
+	Sequence        uint64               `json:"sequence"`
+	Up              bool                 `json:"up"`
+	Stale           bool                 `json:"stale,omitempty"`
+	API             string               `json:"api,omitempty"`
+	GridData        *hubGrid             `json:"gridData,omitempty"`
+	Nodes           []replicatedNode     `json:"nodes,omitempty"`
+	Orphaned        *float64             `json:"orphaned,omitempty"`
+	Duration        time.Duration        `json:"duration"`
+	ScrapedAt       time.Time            `json:"scrapedAt"`
+	LastSuccess     time.Time            `json:"lastSuccess"`
+	LastError       string               `json:"lastError,omitempty"`
+	LastErrorAt     time.Time            `json:"lastErrorAt"`
+	BrowserDemand   map[string]float64   `json:"browserDemand,omitempty"`
+	BrowserSessions map[string]float64   `json:"browserSessions,omitempty"`
+	FeatureUsage    map[string]float64   `json:"featureUsage,omitempty"`
+	FamilyUpdated   map[string]time.Time `json:"familyUpdated,omitempty"`
+	ScrapeErrors    map[string]float64   `json:"scrapeErrors"`
+	Anomalies       map[string]float64   `json:"anomalies"`
+	GraphQLErrors   float64              `json:"graphqlErrors"`
+}
+
+type replicatedNode struct {
+	HubResponseNode
+	Maintenance bool     `json:"maintenance"`
+	Reserved    *float64 `json:"reserved,omitempty"`
+}
+
+func (e *Collector) replicate() replicatedSnapshot {
+	e.mu.Lock()
+	snap := e.last
+	e.mu.Unlock()
+
+	r := replicatedSnapshot{
+		Grid:         e.Name,
+		InstanceID:   e.instanceID,
+		ScrapeErrors: counterValues(e.scrapeErrors),
+		Anomalies:    counterValues(e.anomalies),
+	}
+	var graphQLErrors dto.Metric
+	if err := e.graphQLErrors.Write(&graphQLErrors); err == nil {
+		r.GraphQLErrors = graphQLErrors.GetCounter().GetValue()
+	}
+	if snap == nil {
+		return r
+	}
+	r.Sequence = snap.seq
+	r.Up = snap.up
+	r.Stale = snap.stale
+	r.API = snap.api
+	r.GridData = snap.grid
+	r.Orphaned = snap.orphaned
+	r.Duration = snap.duration
+	r.ScrapedAt = snap.at
+	r.LastSuccess = snap.lastSuccess
+	r.LastError = snap.lastError
+	r.LastErrorAt = snap.lastErrorAt
+	r.BrowserDemand = snap.browserDemand
+	r.BrowserSessions = snap.browserSessions
+	r.FeatureUsage = snap.featureUsage
+	r.FamilyUpdated = snap.familyUpdated
+	for _, n := range snap.nodes {
+		r.Nodes = append(r.Nodes, replicatedNode{HubResponseNode: n.HubResponseNode, Maintenance: n.maintenance, Reserved: n.reservedSlots})
+	}
+	return r
+}
+
+/*
+restore seeds a cold exporter with the snapshot and counters of a peer. The
+snapshot is only used when no scrape has completed yet, while the peer
+counters are always added and the sequence continued from the one of the
+peer, so the series continue instead of resetting.
+*/
+func (e *Collector) restore(r replicatedSnapshot) {
+	for t, v := range r.ScrapeErrors {
+		e.scrapeErrors.WithLabelValues(t).Add(v)
+	}
+	for reason, v := range r.Anomalies {
+		e.anomalies.WithLabelValues(reason).Add(v)
+	}
+	e.graphQLErrors.Add(r.GraphQLErrors)
+
+	snap := &snapshot{
+		up:              r.Up,
+		stale:           r.Stale,
+		api:             r.API,
+		grid:            r.GridData,
+		orphaned:        r.Orphaned,
+		duration:        r.Duration,
+		at:              r.ScrapedAt,
+		lastSuccess:     r.LastSuccess,
+		lastError:       r.LastError,
+		lastErrorAt:     r.LastErrorAt,
+		browserDemand:   r.BrowserDemand,
+		browserSessions: r.BrowserSessions,
+		featureUsage:    r.FeatureUsage,
+		familyUpdated:   r.FamilyUpdated,
+		seq:             r.Sequence,
+	}
+	for _, n := range r.Nodes {
+		node := snapshotNode{HubResponseNode: n.HubResponseNode, maintenance: n.Maintenance}
+		node.reservedSlots = n.Reserved
+		if err := json.Unmarshal([]byte(n.Stereotypes), &node.stereotypes); err != nil {
+			logrus.Debugf("Error decoding replicated stereotypes for node %s: %v", n.Id, err)
+		}
+		snap.nodes = append(snap.nodes, node)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.seq < r.Sequence {
+		e.seq = r.Sequence
+	}
+	if e.last == nil {
+		e.last = snap
+	}
+}
+
+func counterValues(vec *prometheus.CounterVec) map[string]float64 {
+	values := map[string]float64{}
+	ch := make(chan prometheus.Metric)
+	go func() {
+		vec.Collect(ch)
+		close(ch)
+	}()
+	for m := range ch {
+		var metric dto.Metric
+		if err := m.Write(&metric); err != nil {
+			continue
+		}
+		for _, l := range metric.GetLabel() {
+			if l.GetName() != GridLabel {
+				values[l.GetValue()] = metric.GetCounter().GetValue()
+			}
+		}
+	}
+	return values
+}
+
+// NewSnapshotHandler serves the latest snapshot of every target, gzip
+// compressed when the peer accepts it.
+func NewSnapshotHandler(targets *TargetSet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		list := targets.List()
+		snapshots := make([]replicatedSnapshot, 0, len(list))
+		for _, e := range list {
+			snapshots = append(snapshots, e.replicate())
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		var err error
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			if err = json.NewEncoder(gz).Encode(snapshots); err == nil {
+				err = gz.Close()
+			}
+		} else {
+			err = json.NewEncoder(w).Encode(snapshots)
+		}
+		if err != nil {
+			logrus.Errorf("Failed to write snapshot response: %v", err)
+		}
+	})
+}
+
+// PullPeerSnapshots restores the targets from the snapshots of a peer replica,
+// the request being bounded by outbound and timeout. The HTTP transport
+// requests and decodes the gzip encoding by itself.
+func PullPeerSnapshots(ctx context.Context, peerURL, token string, outbound *OutboundManager, timeout time.Duration, targets ...*Collector) error {
+	client := outbound.client(destinationReplication, "", timeout, http.DefaultTransport)
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(peerURL, "/")+"/api/snapshot", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected HTTP status: %s", resp.Status)
+	}
+
+	var snapshots []replicatedSnapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snapshots); err != nil {
+		return err
+	}
+
+	byName := map[string]replicatedSnapshot{}
+	for _, s := range snapshots {
+		byName[s.Grid] = s
+	}
+	for _, e := range targets {
+		if s, ok := byName[e.Name]; ok {
+			e.restore(s)
+			logrus.Infof("Restored %s from peer snapshot of instance %s (sequence %d, last success %s)", e.Name, s.InstanceID, s.Sequence, s.LastSuccess.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
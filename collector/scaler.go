@@ -0,0 +1,198 @@
+package collector
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const browserNameLabel = "browser_name"
+
+// scalerState is the demand and capacity of a Grid as derived from a snapshot,
+// in the shape served on /scaler.
+type scalerState struct {
+	Grid        string          `json:"grid"`
+	InstanceID  string          `json:"instanceId,omitempty"`
+	Sequence    uint64          `json:"sequence"`
+	Up          bool            `json:"up"`
+	Stale       bool            `json:"stale,omitempty"`
+	Maintenance bool            `json:"maintenance"`
+	Sessions    float64         `json:"sessions"`
+	Queued      float64         `json:"queued"`
+	Demand      float64         `json:"demand"`
+	Capacity    float64         `json:"capacity"`
+	Backlog     float64         `json:"backlog"`
+	Browsers    []scalerBrowser `json:"browsers,omitempty"`
+	ScrapedAt   *time.Time      `json:"scrapedAt,omitempty"`
+}
+
+type scalerBrowser struct {
+	BrowserName string   `json:"browserName"`
+	Demand      float64  `json:"demand"`
+	Sessions    float64  `json:"sessions"`
+	Capacity    float64  `json:"capacity"`
+	Ratio       *float64 `json:"ratio,omitempty"` // unset without capacity
+	// Utilization is the ratio of the running sessions to the capacity,
+	// unset without capacity or when the sessions were not queried.
+	Utilization *float64 `json:"utilization,omitempty"`
+}
+
+/*
+scalerStateOf derives the numbers an autoscaler needs from a snapshot. Demand
+is the running plus queued sessions, capacity the sessions the UP nodes which
+are not in maintenance can run. Per-browser demand is only known when the
+snapshot carries the session capabilities and queued requests.
+*/
+func scalerStateOf(name string, snap *snapshot) scalerState {
+	state := scalerState{Grid: name}
+	if snap != nil {
+		state.Sequence = snap.seq
+	}
+	if snap == nil || !(snap.up || snap.stale) || snap.grid == nil {
+		return state
+	}
+	state.Up = snap.up
+	state.Stale = snap.stale
+	if !snap.lastSuccess.IsZero() {
+		state.ScrapedAt = &snap.lastSuccess
+	}
+
+	state.Sessions = snap.grid.SessionCount
+	if snap.grid.SessionQueueSize != nil {
+		state.Queued = *snap.grid.SessionQueueSize
+	}
+	state.Demand = state.Sessions + state.Queued
+
+	browsers := map[string]*scalerBrowser{}
+	browser := func(name string) *scalerBrowser {
+		if browsers[name] == nil {
+			browsers[name] = &scalerBrowser{BrowserName: name}
+		}
+		return browsers[name]
+	}
+
+	for _, n := range snap.nodes {
+		if n.Status != "UP" || n.maintenance {
+			continue
+		}
+		state.Capacity += n.MaxSession
+
+		slots := map[string]float64{}
+		for _, s := range n.stereotypes {
+			slots[s.Stereotype.BrowserName] += float64(s.Slots)
+		}
+		for name, count := range slots {
+			// A node never runs more sessions than maxSession, whatever its slots.
+			browser(name).Capacity += math.Min(count, n.MaxSession)
+		}
+	}
+	state.Backlog = math.Max(0, state.Demand-state.Capacity)
+
+	if snap.browserDemand != nil {
+		for name, demand := range snap.browserDemand {
+			browser(name).Demand = demand
+		}
+		for name, sessions := range snap.browserSessions {
+			browser(name).Sessions = sessions
+		}
+		for _, b := range browsers {
+			if b.Capacity > 0 {
+				ratio := b.Demand / b.Capacity
+				b.Ratio = &ratio
+				if snap.browserSessions != nil {
+					utilization := b.Sessions / b.Capacity
+					b.Utilization = &utilization
+				}
+			}
+			state.Browsers = append(state.Browsers, *b)
+		}
+		sort.Slice(state.Browsers, func(i, j int) bool {
+			return state.Browsers[i].BrowserName < state.Browsers[j].BrowserName
+		})
+	}
+	return state
+}
+
+// browserSessions counts running sessions per browser name.
+func browserSessions(sessions []hubSession) map[string]float64 {
+	running := map[string]float64{}
+	for _, s := range sessions {
+		if name := capabilitiesBrowserName(s.Capabilities); name != "" {
+			running[name]++
+		}
+	}
+	return running
+}
+
+// browserDemand adds the queued requests per browser name to the running
+// sessions.
+func browserDemand(running map[string]float64, queued []string) map[string]float64 {
+	demand := make(map[string]float64, len(running))
+	for name, count := range running {
+		demand[name] = count
+	}
+	for _, q := range queued {
+		if name := capabilitiesBrowserName(q); name != "" {
+			demand[name]++
+		}
+	}
+	return demand
+}
+
+// capabilitiesBrowserName extracts the browser name of a capabilities JSON
+// string, which the session queue may report as a list of alternatives.
+func capabilitiesBrowserName(capabilities string) string {
+	var caps struct {
+		BrowserName string `json:"browserName"`
+	}
+	if err := json.Unmarshal([]byte(capabilities), &caps); err == nil {
+		return caps.BrowserName
+	}
+
+	var alternatives []struct {
+		BrowserName string `json:"browserName"`
+	}
+	if err := json.Unmarshal([]byte(capabilities), &alternatives); err == nil && len(alternatives) > 0 {
+		return alternatives[0].BrowserName
+	}
+	return ""
+}
+
+// NewScalerHandler serves the scaler state of every target as JSON, or of a
+// single one with ?grid=name, for external scalers such as KEDA.
+func NewScalerHandler(maintenance *MaintenanceSchedule, targets *TargetSet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		grid := r.URL.Query().Get("grid")
+		inMaintenance := maintenance.active(time.Now())
+
+		states := []scalerState{}
+		for _, e := range targets.List() {
+			if grid != "" && e.Name != grid {
+				continue
+			}
+			state := scalerStateOf(e.Name, e.current())
+			state.InstanceID = e.instanceID
+			state.Maintenance = inMaintenance
+			states = append(states, state)
+		}
+		if grid != "" && len(states) == 0 {
+			http.Error(w, "unknown grid", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		var err error
+		if grid != "" {
+			err = json.NewEncoder(w).Encode(states[0])
+		} else {
+			err = json.NewEncoder(w).Encode(states)
+		}
+		if err != nil {
+			logrus.Errorf("Failed to write scaler response: %v", err)
+		}
+	})
+}
@@ -0,0 +1,151 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+probeCapabilities carries a capability name which is neither a W3C capability
+nor vendor prefixed. The Grid rejects such a request while validating the
+payload, so it never enters the session queue or reserves a slot.
+*/
+const probeCapabilities = `{"capabilities": {"alwaysMatch": {"selenium-grid-exporter-probe": true}}}`
+
+const (
+	probeResultRejected = "rejected"
+	probeResultCreated  = "created"
+	probeResultError    = "error"
+)
+
+// sessionProbe periodically measures the latency of the new-session path.
+type sessionProbe struct {
+	URI      string
+	client   *http.Client
+	interval time.Duration
+
+	duration *prometheus.HistogramVec
+	success  prometheus.Gauge
+}
+
+type newSessionResponse struct {
+	Value struct {
+		SessionId string `json:"sessionId"`
+		Error     string `json:"error"`
+		Message   string `json:"message"`
+	} `json:"value"`
+}
+
+func newSessionProbe(uri string, client *http.Client, interval time.Duration, labels prometheus.Labels) *sessionProbe {
+	return &sessionProbe{
+		URI:      uri,
+		client:   client,
+		interval: interval,
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   Namespace,
+			Subsystem:   ExporterSubsystem,
+			Name:        "session_probe_duration_seconds",
+			Help:        "Round-trip latency of the invalid new-session probe by result.",
+			ConstLabels: labels,
+			Buckets:     prometheus.ExponentialBuckets(0.005, 2, 12),
+		}, []string{"result"}),
+		success: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   Namespace,
+			Subsystem:   ExporterSubsystem,
+			Name:        "session_probe_success",
+			Help:        "Was the last new-session probe rejected by the Grid as expected.",
+			ConstLabels: labels,
+		}),
+	}
+}
+
+func (p *sessionProbe) run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		result, elapsed := p.probe(ctx)
+		p.duration.WithLabelValues(result).Observe(elapsed.Seconds())
+		p.success.Set(boolToFloat(result == probeResultRejected))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *sessionProbe) probe(ctx context.Context) (string, time.Duration) {
+	req, err := http.NewRequestWithContext(ctx, "POST", p.URI+"/session", strings.NewReader(probeCapabilities))
+	if err != nil {
+		logrus.Errorf("Failed to create session probe request: %v", err)
+		return probeResultError, 0
+	}
+	req.Header.Add("Content-Type", "application/json")
+
+	start := time.Now()
+	resp, err := p.client.Do(req)
+	if err != nil {
+		logrus.Warnf("Session probe failed: %v", err)
+		return probeResultError, time.Since(start)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	elapsed := time.Since(start)
+	if err != nil {
+		logrus.Warnf("Failed to read session probe response: %v", err)
+		return probeResultError, elapsed
+	}
+
+	var session newSessionResponse
+	if err := json.Unmarshal(body, &session); err != nil {
+		logrus.Warnf("Unexpected session probe response (%s): %v", resp.Status, err)
+		return probeResultError, elapsed
+	}
+
+	if resp.StatusCode == http.StatusOK && session.Value.SessionId != "" {
+		logrus.Warnf("Session probe unexpectedly created session %s, deleting it", session.Value.SessionId)
+		p.deleteSession(ctx, session.Value.SessionId)
+		return probeResultCreated, elapsed
+	}
+	if session.Value.Error == "" {
+		logrus.Warnf("Unexpected session probe response: %s", resp.Status)
+		return probeResultError, elapsed
+	}
+
+	logrus.Debugf("Session probe rejected after %s: %s", elapsed, session.Value.Error)
+	return probeResultRejected, elapsed
+}
+
+func (p *sessionProbe) deleteSession(ctx context.Context, id string) {
+	req, err := http.NewRequestWithContext(ctx, "DELETE", p.URI+"/session/"+id, nil)
+	if err != nil {
+		logrus.Errorf("Failed to create session delete request: %v", err)
+		return
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		logrus.Errorf("Failed to delete probe session %s: %v", id, err)
+		return
+	}
+	resp.Body.Close()
+}
+
+func (p *sessionProbe) Describe(ch chan<- *prometheus.Desc) {
+	p.duration.Describe(ch)
+	p.success.Describe(ch)
+}
+
+func (p *sessionProbe) Collect(ch chan<- prometheus.Metric) {
+	p.duration.Collect(ch)
+	ch <- p.success
+}
@@ -0,0 +1,294 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+A scrape runs in three stages, each behind an interface and instrumented with
+its duration and errors:
+
+  - the Fetcher sends the requests to the Grid,
+  - the parser decodes the answers into a snapshot,
+  - the publisher turns the snapshot into const metrics on every collection.
+
+The values of the stage label.
+*/
+const (
+	stageFetch   = "fetch"
+	stageParse   = "parse"
+	stagePublish = "publish"
+
+	stageLabel = "stage"
+)
+
+// FetchRequest is a request of a scrape to a Grid API.
+type FetchRequest struct {
+	// Path is relative to the Grid URI and includes the query string.
+	Path string
+	// Body is sent with POST, requests without body are sent with GET.
+	Body string
+}
+
+/*
+Fetcher is the first stage of a scrape: it sends a request to the Grid and
+returns the body of the answer, which the caller closes. A Grid answering
+with another status than 200 must be reported as *HTTPStatusError; such
+errors are retried for 5xx codes, like all other errors. Options.Fetcher
+replaces the HTTP fetcher, e.g. to replay recorded responses.
+*/
+type Fetcher interface {
+	Fetch(ctx context.Context, req FetchRequest) (io.ReadCloser, error)
+}
+
+// HTTPStatusError is the error of a request the Grid answered with another
+// status than 200.
+type HTTPStatusError struct {
+	Code   int
+	Status string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return "unexpected HTTP status: " + e.Status
+}
+
+// errInvalidRequest marks requests which could not be built, and are not
+// worth retrying.
+var errInvalidRequest = errors.New("invalid request")
+
+// retryable reports whether a failed fetch may succeed when sent again:
+// network errors and 5xx answers.
+func retryable(err error) bool {
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code >= 500
+	}
+	return !errors.Is(err, errInvalidRequest)
+}
+
+// httpFetcher is the default Fetcher, sending the requests through the HTTP
+// client of the collector. The Go transport asks for gzip and decompresses
+// the body transparently.
+type httpFetcher struct {
+	uri    string
+	client *http.Client
+	// observe records the latency of every request, up to the end of its
+	// body for the answered ones.
+	observe func(path string, d time.Duration, err error)
+}
+
+func (f *httpFetcher) Fetch(ctx context.Context, r FetchRequest) (io.ReadCloser, error) {
+	method, body := "GET", io.Reader(nil)
+	if r.Body != "" {
+		method, body = "POST", strings.NewReader(r.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, f.uri+r.Path, body)
+	if err != nil {
+		logrus.Errorf("Failed to create request: %v", err)
+		return nil, fmt.Errorf("%w: %v", errInvalidRequest, err)
+	}
+	if r.Body != "" {
+		req.Header.Add("Content-Type", "application/json")
+	}
+
+	start := time.Now()
+	resp, err := f.client.Do(req)
+	if err != nil {
+		f.observe(req.URL.Path, time.Since(start), err)
+		logrus.Errorf("Failed to execute request: %v", err)
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		f.observe(req.URL.Path, time.Since(start), nil)
+		logrus.Debugf("Unexpected HTTP status from %s: %s", req.URL.Path, resp.Status)
+		return nil, &HTTPStatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+	return &observedBody{ReadCloser: resp.Body, path: req.URL.Path, start: start, observe: f.observe}, nil
+}
+
+/*
+observedBody reports the latency of a request once its body is closed, so a
+Grid slow to stream a large answer counts as slow, and a timeout hit while
+reading the body is observed like one hit waiting for the headers.
+*/
+type observedBody struct {
+	io.ReadCloser
+	path    string
+	start   time.Time
+	observe func(path string, d time.Duration, err error)
+	err     error
+}
+
+func (b *observedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
+
+func (b *observedBody) Close() error {
+	if b.observe != nil {
+		b.observe(b.path, time.Since(b.start), b.err)
+		b.observe = nil
+	}
+	return b.ReadCloser.Close()
+}
+
+/*
+parser is the second stage of a scrape: it decodes the answer of a Grid 4 API
+into a hubResponse. The GraphQL response is decoded while it is read, so the
+time spent reading a large body counts in this stage rather than in the fetch.
+*/
+type parser interface {
+	parse(api string, body io.Reader, hResponse *hubResponse) error
+}
+
+// htmlError is returned by the parser for an HTML page answered instead of
+// JSON, e.g. by a reverse proxy in front of the Grid.
+type htmlError struct {
+	head []byte
+}
+
+func (e *htmlError) Error() string {
+	return "Selenium Grid returned an HTML page instead of JSON: " + snippet(e.head, 120)
+}
+
+// gridParser is the default parser. The body is only kept in full when custom
+// fields have to be read from it.
+type gridParser struct {
+	customFields []CustomField
+	anonymizer   *Anonymizer // of the URIs in custom field labels
+}
+
+func (p *gridParser) parse(api string, body io.Reader, hResponse *hubResponse) error {
+	br := bufio.NewReaderSize(body, htmlSniffLen)
+	head, err := br.Peek(htmlSniffLen)
+	if looksLikeHTML(head) {
+		return &htmlError{head: head}
+	}
+	if len(head) == 0 && err != nil {
+		// Empty body, or the connection failed before the first byte.
+		return err
+	}
+
+	if api == APIStatus {
+		data, err := io.ReadAll(br)
+		if err != nil {
+			return err
+		}
+		return decodeGridStatus(data, hResponse, time.Now())
+	}
+
+	var r io.Reader = br
+	var raw bytes.Buffer
+	if len(p.customFields) > 0 {
+		r = io.TeeReader(br, &raw)
+	}
+	if err := decodeGraphQLStream(json.NewDecoder(r), hResponse); err != nil {
+		return err
+	}
+	if len(p.customFields) > 0 {
+		var err error
+		hResponse.custom, err = customSamples(p.customFields, raw.Bytes(), p.anonymizer)
+		return err
+	}
+	return nil
+}
+
+/*
+publisher is the last stage of a scrape: it turns a snapshot into const
+metrics, on every collection. Metrics which can't be built, e.g. because the
+Grid reported a label value which isn't valid UTF-8, are left out and
+returned as error instead of failing the whole collection.
+*/
+type publisher interface {
+	publish(ch chan<- prometheus.Metric, snap *snapshot) error
+}
+
+// publishErrors collects the errors of the metrics of a publication, which
+// may be built concurrently.
+type publishErrors struct {
+	mu    sync.Mutex
+	count int
+	first error
+}
+
+func (p *publishErrors) add(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.count == 0 {
+		p.first = err
+	}
+	p.count++
+}
+
+func (p *publishErrors) err() error {
+	if p.count == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d metrics left out: %w", p.count, p.first)
+}
+
+// gauge returns a function sending gauges to ch, leaving out the metrics which
+// can't be built and recording their errors.
+func (p *publishErrors) gauge(ch chan<- prometheus.Metric) func(*prometheus.Desc, float64, ...string) {
+	return func(desc *prometheus.Desc, value float64, labels ...string) {
+		m, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, value, labels...)
+		if err != nil {
+			p.add(err)
+			return
+		}
+		ch <- m
+	}
+}
+
+// reportPublish logs the metrics of a part of a collector which were left
+// out, and counts them as a failure of the publish stage.
+func reportPublish(errs *publishErrors, what string, failures prometheus.Counter) {
+	err := errs.err()
+	if err == nil {
+		return
+	}
+	if failures != nil {
+		failures.Inc()
+	}
+	logrus.Errorf("Error publishing the %s metrics: %v", what, err)
+}
+
+func newStageMetrics(labels prometheus.Labels) (*prometheus.HistogramVec, *prometheus.CounterVec) {
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   Namespace,
+		Subsystem:   ExporterSubsystem,
+		Name:        "stage_duration_seconds",
+		Help:        "Duration of the scrape stages: fetch per Grid request including its retries, parse per scrape, publish per collection.",
+		ConstLabels: labels,
+		Buckets:     prometheus.ExponentialBuckets(0.0005, 4, 9),
+	}, []string{stageLabel})
+	errs := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   Namespace,
+		Subsystem:   ExporterSubsystem,
+		Name:        "stage_errors_total",
+		Help:        "Number of failures of the scrape stages (fetch, parse, publish).",
+		ConstLabels: labels,
+	}, []string{stageLabel})
+	for _, stage := range []string{stageFetch, stageParse, stagePublish} {
+		errs.WithLabelValues(stage)
+	}
+	return duration, errs
+}
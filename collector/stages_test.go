@@ -0,0 +1,252 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestGridParser(t *testing.T) {
+	p := &gridParser{}
+
+	var graphQL hubResponse
+	if err := p.parse(APIGraphQL, bytes.NewReader(fixture(t, "graphql.json")), &graphQL); err != nil {
+		t.Fatal(err)
+	}
+	grid := graphQL.Data.Grid
+	if grid.TotalSlots != 4 || grid.SessionCount != 1 || grid.NodeCount != 2 || grid.SessionQueueSize == nil || *grid.SessionQueueSize != 2 {
+		t.Errorf("got grid %+v from GraphQL", grid)
+	}
+	if nodes := graphQL.Data.NodesInfo.Nodes; len(nodes) != 2 || nodes[1].Status != "DRAINING" || nodes[0].OsInfo.Arch != "amd64" {
+		t.Errorf("got nodes %+v from GraphQL", nodes)
+	}
+	if info := graphQL.Data.SessionsInfo; len(info.Sessions) != 1 || len(info.SessionQueueRequests) != 2 {
+		t.Errorf("got sessions %+v from GraphQL", info)
+	}
+
+	var status hubResponse
+	if err := p.parse(APIStatus, bytes.NewReader(fixture(t, "status.json")), &status); err != nil {
+		t.Fatal(err)
+	}
+	grid = status.Data.Grid
+	if grid.TotalSlots != 3 || grid.MaxSession != 3 || grid.SessionCount != 1 || grid.NodeCount != 1 || grid.SessionQueueSize != nil {
+		t.Errorf("got grid %+v from /status", grid)
+	}
+	node := status.Data.NodesInfo.Nodes[0]
+	if node.reservedSlots == nil || *node.reservedSlots != 1 {
+		t.Errorf("got reserved slots %v from /status, want 1", node.reservedSlots)
+	}
+	if !strings.Contains(node.Stereotypes, `"slots":2`) || !strings.Contains(node.Stereotypes, "firefox") {
+		t.Errorf("got stereotypes %s from /status", node.Stereotypes)
+	}
+
+	var htmlErr *htmlError
+	if err := p.parse(APIGraphQL, bytes.NewReader(fixture(t, "proxy.html")), &hubResponse{}); !errors.As(err, &htmlErr) {
+		t.Errorf("got %v for an HTML page, want an htmlError", err)
+	}
+}
+
+func TestScrapeStageErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		body      []byte
+		errorType string
+		stage     string
+	}{
+		{"html", fixture(t, "proxy.html"), errorTypeHTML, stageParse},
+		{"syntax", []byte(`{"data": {"grid": {"totalSlots": 4,}}}`), errorTypeDecode, stageParse},
+		{"empty", nil, errorTypeDecode, stageParse},
+		{"blank", []byte(" \n"), errorTypeDecode, stageParse},
+		{"truncated", fixture(t, "graphql.json")[:200], errorTypeDecode, stageParse},
+		{"graphql", fixture(t, "graphql-errors.json"), errorTypeGraphQL, stageParse},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := NewCollector(Options{Name: "test", URI: "http://grid.test", Fetcher: replayFetcher{"/graphql": tc.body}})
+			if snap := e.scrape(); snap.up {
+				t.Fatal("scrape succeeded")
+			}
+			if n := counterValue(t, e.scrapeErrors.WithLabelValues(tc.errorType)); n != 1 {
+				t.Errorf("got %v scrape errors of type %s, want 1", n, tc.errorType)
+			}
+			if n := counterValue(t, e.stageErrors.WithLabelValues(tc.stage)); n != 1 {
+				t.Errorf("got %v errors of stage %s, want 1", n, tc.stage)
+			}
+		})
+	}
+}
+
+func TestHTTPFetcher(t *testing.T) {
+	grid := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/graphql" && r.Method == http.MethodPost && r.Header.Get("Content-Type") == "application/json":
+			w.Write([]byte(`{"data": {}}`))
+		case r.URL.Path == "/status":
+			http.Error(w, "maintenance", http.StatusServiceUnavailable)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer grid.Close()
+
+	var observed []string
+	f := &httpFetcher{uri: grid.URL, client: grid.Client(), observe: func(path string, _ time.Duration, _ error) {
+		observed = append(observed, path)
+	}}
+
+	body, err := f.Fetch(context.Background(), graphQLRequest("{ grid { version } }"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body.Close()
+
+	_, err = f.Fetch(context.Background(), statusRequest)
+	var statusErr *HTTPStatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != http.StatusServiceUnavailable || !retryable(err) {
+		t.Errorf("got %v for a 503, want a retryable HTTPStatusError", err)
+	}
+	_, err = f.Fetch(context.Background(), FetchRequest{Path: "/missing"})
+	if retryable(err) || !isAPIUnavailable(err) {
+		t.Errorf("got %v for a 404, want an unavailable API", err)
+	}
+	if _, err = f.Fetch(context.Background(), FetchRequest{Path: "/%zz"}); retryable(err) {
+		t.Errorf("got retryable %v for an invalid request", err)
+	}
+
+	if want := []string{"/graphql", "/status", "/missing"}; strings.Join(observed, " ") != strings.Join(want, " ") {
+		t.Errorf("observed requests %v, want %v", observed, want)
+	}
+}
+
+func TestAdaptiveTimeoutObservesSlowBody(t *testing.T) {
+	body := gridResponse(3)
+	grid := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body[:len(body)/2])
+		w.(http.Flusher).Flush()
+		select {
+		case <-time.After(time.Second):
+			w.Write(body[len(body)/2:])
+		case <-r.Context().Done():
+		}
+	}))
+	defer grid.Close()
+
+	const timeout = 200 * time.Millisecond
+	e := NewCollector(Options{
+		Name:            "test",
+		URI:             grid.URL,
+		Timeout:         timeout,
+		AdaptiveTimeout: &AdaptiveTimeoutOptions{Percentile: 0.99, Multiplier: 3, Min: 10 * time.Millisecond},
+	})
+	if snap := e.scrape(); snap.up {
+		t.Fatal("scrape of a body slower than the timeout succeeded")
+	}
+
+	samples := e.adaptiveTimeout.samples
+	if len(samples) != 1 {
+		t.Fatalf("got %d latency samples, want the timeout hit while reading the body", len(samples))
+	}
+	if samples[0] < timeout {
+		t.Errorf("got latency %s, want at least the timeout %s", samples[0], timeout)
+	}
+}
+
+func BenchmarkParseTruncated(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		body []byte
+	}{
+		{"empty", nil},
+		{"truncated", fixture(b, "graphql.json")[:200]},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			p := &gridParser{}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := p.parse(APIGraphQL, bytes.NewReader(bc.body), &hubResponse{}); err == nil {
+					b.Fatal("parsed a truncated response")
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkParseGraphQL compares decoding a large GraphQL response while it
+// is read to buffering it whole before unmarshalling it.
+func BenchmarkParseGraphQL(b *testing.B) {
+	body := gridResponse(2000)
+	for _, bc := range []struct {
+		name  string
+		parse func(io.Reader, *hubResponse) error
+	}{
+		{"stream", func(r io.Reader, hResponse *hubResponse) error {
+			return (&gridParser{}).parse(APIGraphQL, r, hResponse)
+		}},
+		{"buffered", func(r io.Reader, hResponse *hubResponse) error {
+			body, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			return json.Unmarshal(body, hResponse)
+		}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.SetBytes(int64(len(body)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var hResponse hubResponse
+				if err := bc.parse(bytes.NewReader(body), &hResponse); err != nil {
+					b.Fatal(err)
+				}
+				if n := len(hResponse.Data.NodesInfo.Nodes); n != 2000 {
+					b.Fatalf("got %d nodes, want 2000", n)
+				}
+			}
+		})
+	}
+}
+
+func TestPublishInvalidLabelValues(t *testing.T) {
+	failures := prometheus.NewCounter(prometheus.CounterOpts{Name: "failures"})
+	s := newNodeStatusScheduler(nil, time.Second, 0, prometheus.Labels{GridLabel: "test"})
+	s.top = 3
+	s.publishFailures = failures
+	s.results["\xff"] = nodeStatusResult{target: nodeTarget{Id: "\xff", Uri: "http://10.0.0.1:5555"}, up: true, ready: true}
+	s.results["node"] = nodeStatusResult{target: nodeTarget{Id: "node", Uri: "http://10.0.0.2:5555"}, up: true, ready: true}
+
+	ch := make(chan prometheus.Metric, 100)
+	s.Collect(ch)
+	close(ch)
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range pb.GetLabel() {
+			if l.GetName() == nodeIdLabel && l.GetValue() != "node" {
+				t.Errorf("got a metric of node %q", l.GetValue())
+			}
+		}
+	}
+	if n := counterValue(t, failures); n != 1 {
+		t.Errorf("got %v publish failures, want 1", n)
+	}
+}
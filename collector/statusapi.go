@@ -0,0 +1,138 @@
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// reservedSessionId marks slots the distributor reserved for a session which
+// is still being created.
+const reservedSessionId = "reserved"
+
+const (
+	APIGraphQL = "graphql"
+	APIStatus  = "status"
+	APIAuto    = "auto"
+)
+
+// gridStatusResponse is the part of the router GET /status response the
+// exporter derives its metrics from when GraphQL is not available.
+type gridStatusResponse struct {
+	Value struct {
+		Ready bool             `json:"ready"`
+		Nodes []gridStatusNode `json:"nodes"`
+	} `json:"value"`
+}
+
+type gridStatusNode struct {
+	Id           string     `json:"id"`
+	Uri          string     `json:"uri"`
+	MaxSessions  float64    `json:"maxSessions"`
+	Availability string     `json:"availability"`
+	Version      string     `json:"version"`
+	OsInfo       nodeOsInfo `json:"osInfo"`
+	Slots        []struct {
+		Session *struct {
+			SessionId string `json:"sessionId"`
+			Start     string `json:"start"`
+		} `json:"session"`
+		Stereotype struct {
+			BrowserName    string         `json:"browserName"`
+			BrowserVersion string         `json:"browserVersion"`
+			PlatformName   string         `json:"platformName"`
+			VNCEnabled     capabilityFlag `json:"se:vncEnabled"`
+			RecordVideo    capabilityFlag `json:"se:recordVideo"`
+		} `json:"stereotype"`
+	} `json:"slots"`
+}
+
+var statusRequest = FetchRequest{Path: "/status"}
+
+// isAPIUnavailable reports whether err means the endpoint is disabled or
+// blocked, as opposed to the Grid failing to answer.
+func isAPIUnavailable(err error) bool {
+	var statusErr *HTTPStatusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+	return statusErr.Code == http.StatusNotFound || statusErr.Code == http.StatusMethodNotAllowed
+}
+
+/*
+decodeGridStatus derives the GraphQL view of the Grid from a /status response:
+slot and session counts are summed up over the nodes, and slots sharing a
+stereotype are grouped like the GraphQL stereotypes field does. The session
+queue size and the Grid version are not part of /status and stay unset.
+*/
+func decodeGridStatus(body []byte, hResponse *hubResponse, now time.Time) error {
+	var status gridStatusResponse
+	if err := json.Unmarshal(body, &status); err != nil {
+		return err
+	}
+
+	grid := &hResponse.Data.Grid
+	for _, n := range status.Value.Nodes {
+		node := HubResponseNode{
+			Id:         n.Id,
+			Uri:        n.Uri,
+			Status:     n.Availability,
+			MaxSession: n.MaxSessions,
+			SlotCount:  float64(len(n.Slots)),
+			Version:    n.Version,
+			OsInfo:     n.OsInfo,
+		}
+
+		var stereotypes []Stereotype
+		reserved := 0.0
+		node.reservedSlots = &reserved
+		for _, slot := range n.Slots {
+			if slot.Session != nil && slot.Session.SessionId == reservedSessionId {
+				reserved++
+			} else if slot.Session != nil {
+				node.SessionCount++
+				capabilities, _ := json.Marshal(slot.Stereotype)
+				hResponse.Data.SessionsInfo.Sessions = append(hResponse.Data.SessionsInfo.Sessions, hubSession{
+					Id:                    slot.Session.SessionId,
+					NodeId:                n.Id,
+					SessionDurationMillis: sessionDurationMillis(slot.Session.Start, now),
+					Capabilities:          string(capabilities),
+				})
+			}
+
+			found := false
+			for i := range stereotypes {
+				if stereotypes[i].Stereotype == slot.Stereotype {
+					stereotypes[i].Slots++
+					found = true
+					break
+				}
+			}
+			if !found {
+				stereotypes = append(stereotypes, Stereotype{Slots: 1, Stereotype: slot.Stereotype})
+			}
+		}
+		encoded, err := json.Marshal(stereotypes)
+		if err != nil {
+			return err
+		}
+		node.Stereotypes = string(encoded)
+
+		grid.TotalSlots += node.SlotCount
+		grid.MaxSession += node.MaxSession
+		grid.SessionCount += node.SessionCount
+		hResponse.Data.NodesInfo.Nodes = append(hResponse.Data.NodesInfo.Nodes, node)
+	}
+	grid.NodeCount = float64(len(status.Value.Nodes))
+	return nil
+}
+
+func sessionDurationMillis(start string, now time.Time) json.Number {
+	started, err := time.Parse(time.RFC3339Nano, start)
+	if err != nil {
+		return ""
+	}
+	return json.Number(strconv.FormatInt(now.Sub(started).Milliseconds(), 10))
+}
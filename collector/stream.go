@@ -0,0 +1,106 @@
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// htmlSniffLen is how much of a response is looked at to tell an HTML error
+// page from JSON.
+const htmlSniffLen = 512
+
+/*
+failParse records a failure of the parser by its cause: an HTML page answered
+by a proxy, a response which can't be decoded, or the connection failing while
+the body was read. An empty or truncated body is a decode error: the decoder
+only returns io.EOF before the first token, and io.ErrUnexpectedEOF within a
+value.
+*/
+func (e *Collector) failParse(snap *snapshot, err error) {
+	var htmlErr *htmlError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &htmlErr):
+		e.isHTML(snap, htmlErr.head)
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr), errors.Is(err, errUnexpectedToken),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		e.failDecode(snap, err)
+	default:
+		// The connection failed while the body was read.
+		e.failFetch(snap, err)
+	}
+}
+
+var errUnexpectedToken = errors.New("unexpected JSON token")
+
+/*
+decodeGraphQLStream walks the response token by token down to the nodes, which
+are decoded one at a time, so the response is never held in memory as a
+whole. Everything else is decoded as usual.
+*/
+func decodeGraphQLStream(dec *json.Decoder, hResponse *hubResponse) error {
+	data := &hResponse.Data
+	return decodeObject(dec, func(key string) error {
+		switch key {
+		case "errors":
+			return dec.Decode(&hResponse.Errors)
+		case "data":
+			return decodeObject(dec, func(key string) error {
+				switch key {
+				case "grid":
+					return dec.Decode(&data.Grid)
+				case "sessionsInfo":
+					return dec.Decode(&data.SessionsInfo)
+				case "nodesInfo":
+					return decodeObject(dec, func(key string) error {
+						if key == "nodes" {
+							// The grid totals come first in the response.
+							return decodeNodes(dec, &data.NodesInfo.Nodes, int(data.Grid.NodeCount))
+						}
+						return skipValue(dec)
+					})
+				}
+				return skipValue(dec)
+			})
+		}
+		return skipValue(dec)
+	})
+}
+
+// decodeObject calls field for every key of the next JSON object, which must
+// consume the value. A null object has no keys.
+func decodeObject(dec *json.Decoder, field func(key string) error) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return nil
+	}
+	if tok != json.Delim('{') {
+		return fmt.Errorf("%w %v, expected an object", errUnexpectedToken, tok)
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return fmt.Errorf("%w %v, expected a key", errUnexpectedToken, tok)
+		}
+		if err := field(key); err != nil {
+			return err
+		}
+	}
+	_, err = dec.Token() // closing brace
+	return err
+}
+
+func skipValue(dec *json.Decoder) error {
+	var skipped json.RawMessage
+	return dec.Decode(&skipped)
+}
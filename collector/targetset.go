@@ -0,0 +1,39 @@
+package collector
+
+import "sync"
+
+// TargetSet is the set of collectors an exporter serves. It is safe for
+// concurrent use, so Grids can be added and removed while the handlers built
+// on it are serving, e.g. as Kubernetes discovery finds and loses them.
+type TargetSet struct {
+	mu         sync.RWMutex
+	collectors []*Collector
+}
+
+func NewTargetSet(collectors ...*Collector) *TargetSet {
+	return &TargetSet{collectors: collectors}
+}
+
+// List returns the collectors of the set in the order they were added.
+func (s *TargetSet) List() []*Collector {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]*Collector(nil), s.collectors...)
+}
+
+func (s *TargetSet) Add(e *Collector) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.collectors = append(s.collectors, e)
+}
+
+func (s *TargetSet) Remove(e *Collector) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, c := range s.collectors {
+		if c == e {
+			s.collectors = append(s.collectors[:i:i], s.collectors[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package collector
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const rankLabel = "rank"
+
+// rankedNode is a node with the value it is ranked by.
+type rankedNode struct {
+	target nodeTarget
+	value  float64
+}
+
+/*
+topNodes returns the n nodes with the highest non-zero value, highest first.
+Ties are broken by node ID so ranks don't shuffle between scrapes. The result
+is bounded by n whatever the size of the Grid, which keeps the top series
+usable where per-node series are disabled.
+*/
+func topNodes(nodes []rankedNode, n int) []rankedNode {
+	top := make([]rankedNode, 0, len(nodes))
+	for _, node := range nodes {
+		if node.value > 0 {
+			top = append(top, node)
+		}
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].value != top[j].value {
+			return top[i].value > top[j].value
+		}
+		return top[i].target.Id < top[j].target.Id
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+func newTopNodesDesc(name, help string, labels prometheus.Labels) *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, nodeSubsystem, name),
+		help,
+		[]string{rankLabel, nodeIdLabel, nodeUriLabel}, labels)
+}
+
+func collectTopNodes(gauge func(*prometheus.Desc, float64, ...string), anonymizer *Anonymizer, desc *prometheus.Desc, nodes []rankedNode, n int) {
+	for i, node := range topNodes(nodes, n) {
+		gauge(desc, node.value, strconv.Itoa(i+1), node.target.Id, anonymizer.uri(node.target.Uri))
+	}
+}
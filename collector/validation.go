@@ -0,0 +1,67 @@
+package collector
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	anomalyNegative      = "negative"
+	anomalyOvercommit    = "overcommit"
+	anomalySlotsVanished = "slots_vanished"
+	anomalyReasonLabel   = "reason"
+	maxSessionOvercommit = 2.0
+)
+
+func newAnomaliesCounter(labels prometheus.Labels) *prometheus.CounterVec {
+	anomalies := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   Namespace,
+		Subsystem:   ExporterSubsystem,
+		Name:        "anomalous_samples_total",
+		Help:        "Number of scrapes with physically impossible values by reason.",
+		ConstLabels: labels,
+	}, []string{anomalyReasonLabel})
+	for _, r := range []string{anomalyNegative, anomalyOvercommit, anomalySlotsVanished} {
+		anomalies.WithLabelValues(r)
+	}
+	return anomalies
+}
+
+/*
+validateSnapshot checks a freshly scraped snapshot for values the Grid cannot
+physically be in, using the previous snapshot as reference: negative counts,
+far more sessions than the Grid allows, or all slots vanishing at once while
+nodes are still reported UP. It returns one description per anomaly reason.
+*/
+func validateSnapshot(prev, cur *snapshot) map[string]string {
+	anomalies := map[string]string{}
+	grid := cur.grid
+	if grid == nil {
+		return anomalies
+	}
+
+	if grid.TotalSlots < 0 || grid.MaxSession < 0 || grid.SessionCount < 0 || grid.NodeCount < 0 ||
+		(grid.SessionQueueSize != nil && *grid.SessionQueueSize < 0) {
+		anomalies[anomalyNegative] = "negative grid count"
+	}
+	for _, n := range cur.nodes {
+		if n.MaxSession < 0 || n.SlotCount < 0 || n.SessionCount < 0 {
+			anomalies[anomalyNegative] = fmt.Sprintf("negative count on node %s", n.Id)
+		}
+	}
+
+	if grid.MaxSession > 0 && grid.SessionCount > grid.MaxSession*maxSessionOvercommit {
+		anomalies[anomalyOvercommit] = fmt.Sprintf("%.0f sessions for a maximum of %.0f", grid.SessionCount, grid.MaxSession)
+	}
+
+	if grid.TotalSlots == 0 && prev != nil && prev.grid != nil && prev.grid.TotalSlots > 0 {
+		for _, n := range cur.nodes {
+			if n.Status == "UP" {
+				anomalies[anomalySlotsVanished] = fmt.Sprintf("total slots dropped from %.0f to 0 while node %s is UP", prev.grid.TotalSlots, n.Id)
+				break
+			}
+		}
+	}
+	return anomalies
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/wakeful/selenium_grid_exporter/collector"
+)
+
+// fileConfig holds the settings which can only be provided through the
+// optional configuration file given with -config-file.
+type fileConfig struct {
+	Targets            []gridTarget                     `yaml:"targets"`
+	MaintenanceWindows []collector.MaintenanceWindow    `yaml:"maintenance_windows"`
+	NodeMaintenance    []collector.NodeMaintenanceEntry `yaml:"node_maintenance"`
+	Relabel            []relabelRule                    `yaml:"relabel"`
+	GraphQLFields      []collector.CustomField          `yaml:"graphql_fields"`
+}
+
+func loadConfig(path string) (*fileConfig, error) {
+	cfg := &fileConfig{}
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+	if data, err = expandEnv(data); err != nil {
+		return nil, fmt.Errorf("expanding config file %s: %w", path, err)
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	for i, w := range cfg.MaintenanceWindows {
+		if !w.End.After(w.Start) {
+			return nil, fmt.Errorf("maintenance window %d (%s) must end after it starts", i, w.Name)
+		}
+	}
+	names := map[string]bool{}
+	for i, t := range cfg.Targets {
+		if t.URI == "" {
+			return nil, fmt.Errorf("target %d has no uri", i)
+		}
+		if t.Name == "" {
+			cfg.Targets[i].Name = defaultGridName(t.URI)
+		}
+		if names[cfg.Targets[i].Name] {
+			return nil, fmt.Errorf("duplicate target name %q", cfg.Targets[i].Name)
+		}
+		names[cfg.Targets[i].Name] = true
+		if t.GridVersion != 0 && t.GridVersion != 3 && t.GridVersion != 4 {
+			return nil, fmt.Errorf("target %s has unknown grid_version %d, expected 3 or 4", cfg.Targets[i].Name, t.GridVersion)
+		}
+
+		for j, c := range t.Components {
+			if c.Name == "" || c.URI == "" {
+				return nil, fmt.Errorf("component %d of target %s needs a name and a uri", j, cfg.Targets[i].Name)
+			}
+		}
+	}
+
+	for i := range cfg.NodeMaintenance {
+		if err := cfg.NodeMaintenance[i].Compile(); err != nil {
+			return nil, err
+		}
+	}
+	for i := range cfg.Relabel {
+		if err := cfg.Relabel[i].compile(); err != nil {
+			return nil, err
+		}
+	}
+	metrics := map[string]bool{}
+	for i := range cfg.GraphQLFields {
+		if err := cfg.GraphQLFields[i].Compile(); err != nil {
+			return nil, err
+		}
+		if metrics[cfg.GraphQLFields[i].Metric] {
+			return nil, fmt.Errorf("duplicate custom field metric %q", cfg.GraphQLFields[i].Metric)
+		}
+		metrics[cfg.GraphQLFields[i].Metric] = true
+	}
+	return cfg, nil
+}
+
+// envReference matches ${NAME} and ${NAME:-default}, and their escaped form
+// with a leading $.
+var envReference = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(:-[^}]*)?\}`)
+
+/*
+expandEnv substitutes the environment variables referenced in the config file
+as ${NAME}, or ${NAME:-default} to fall back on default when NAME is unset or
+empty. $${NAME} is kept as ${NAME}. Other uses of $, such as the $1 of relabel
+replacements, are left alone. Referencing an unset variable without default is
+an error, rather than silently scraping an empty URI.
+*/
+func expandEnv(data []byte) ([]byte, error) {
+	var missing []string
+	expanded := envReference.ReplaceAllFunc(data, func(ref []byte) []byte {
+		if ref[1] == '$' {
+			return ref[1:]
+		}
+		m := envReference.FindSubmatch(ref)
+		if value := os.Getenv(string(m[1])); value != "" {
+			return []byte(value)
+		}
+		if m[2] != nil {
+			return m[2][2:]
+		}
+		if _, ok := os.LookupEnv(string(m[1])); !ok && !slices.Contains(missing, string(m[1])) {
+			missing = append(missing, string(m[1]))
+		}
+		return nil
+	})
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("undefined environment variables %s", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var envFile = flag.String("env-file", getEnv("ENV_FILE", ""), "Path to a file of KEY=VALUE pairs loaded before parsing flags.")
+
+/*
+loadEnvFile applies the env file named by -env-file (or ENV_FILE) before the
+command line is parsed. Variables already present in the environment win over
+the file, and flags given on the command line win over both.
+*/
+func loadEnvFile(args []string) error {
+	path := envFileArg(args)
+	if path == "" {
+		return nil
+	}
+
+	values, err := readEnvFile(path)
+	if err != nil {
+		return err
+	}
+
+	loaded := map[string]string{}
+	for key, value := range values {
+		if _, exists := os.LookupEnv(key); exists {
+			continue
+		}
+		os.Setenv(key, value)
+		loaded[key] = value
+	}
+
+	// Flag defaults were computed from the environment at startup, so
+	// update the flags bound to the newly set variables.
+	var setErr error
+	flag.VisitAll(func(f *flag.Flag) {
+		key := envNameFromFlag(f.Name)
+		if value, ok := loaded[key]; ok && setErr == nil {
+			if err := f.Value.Set(value); err != nil {
+				setErr = fmt.Errorf("invalid value %q for %s in %s: %w", value, key, path, err)
+			}
+		}
+	})
+	return setErr
+}
+
+// envFileArg returns the env file given on the command line, if any.
+func envFileArg(args []string) string {
+	for i, arg := range args {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "env-file" {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return *envFile
+}
+
+func readEnvFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening env file: %w", err)
+	}
+	defer file.Close()
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, lineNo)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading env file: %w", err)
+	}
+	return values, nil
+}
+
+// envNameFromFlag maps a flag such as scrape-uri or log.level to the
+// environment variable configuring it (SCRAPE_URI, LOG_LEVEL).
+func envNameFromFlag(name string) string {
+	return strings.NewReplacer("-", "_", ".", "_").Replace(strings.ToUpper(name))
+}
@@ -0,0 +1,174 @@
+//go:build chaos
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/wakeful/selenium_grid_exporter/collector"
+)
+
+var faultInjection = flag.String("fault-injection", getEnv("FAULT_INJECTION", ""), "Comma separated fault=probability[:parameter] list of faults injected into the Grid responses: reset, error[:status], delay[:max], truncate, corrupt. Test builds only.")
+
+var errInjectedReset = errors.New("connection reset by fault injection")
+
+// Kinds of injected faults, the values of the fault label.
+const (
+	faultReset    = "reset"
+	faultError    = "error"
+	faultDelay    = "delay"
+	faultTruncate = "truncate"
+	faultCorrupt  = "corrupt"
+)
+
+/*
+faultTransport randomly breaks the requests to the Grid, to exercise the
+retries and the handling of partial responses: connections are reset, errors
+answered, responses delayed, cut short or garbled. Every fault is drawn
+independently with its own probability.
+*/
+type faultTransport struct {
+	next http.RoundTripper
+
+	probability map[string]float64 // by fault
+	status      int
+	maxDelay    time.Duration
+
+	injected *prometheus.CounterVec
+}
+
+func parseFaults(spec string, next http.RoundTripper) (*faultTransport, error) {
+	t := &faultTransport{next: next, probability: map[string]float64{}, status: http.StatusServiceUnavailable, maxDelay: 2 * time.Second}
+	for _, item := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid fault %q, expected fault=probability", item)
+		}
+		value, param, _ := strings.Cut(value, ":")
+		p, err := strconv.ParseFloat(value, 64)
+		if err != nil || p < 0 || p > 1 {
+			return nil, fmt.Errorf("invalid probability %q of fault %s, expected a number between 0 and 1", value, name)
+		}
+
+		switch name {
+		case faultReset, faultTruncate, faultCorrupt:
+		case faultError:
+			if param != "" {
+				if t.status, err = strconv.Atoi(param); err != nil || t.status < 100 || t.status > 599 {
+					return nil, fmt.Errorf("invalid status %q of fault error", param)
+				}
+			}
+		case faultDelay:
+			if param != "" {
+				if t.maxDelay, err = time.ParseDuration(param); err != nil || t.maxDelay <= 0 {
+					return nil, fmt.Errorf("invalid maximum %q of fault delay", param)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("unknown fault %q, expected reset, error, delay, truncate or corrupt", name)
+		}
+		t.probability[name] = p
+	}
+
+	t.injected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: collector.Namespace,
+		Subsystem: collector.ExporterSubsystem,
+		Name:      "injected_faults_total",
+		Help:      "Number of faults injected into the Grid responses by kind.",
+	}, []string{"fault"})
+	for _, f := range []string{faultReset, faultError, faultDelay, faultTruncate, faultCorrupt} {
+		t.injected.WithLabelValues(f)
+	}
+	return t, nil
+}
+
+func (t *faultTransport) happens(fault string) bool {
+	if p := t.probability[fault]; p == 0 || rand.Float64() >= p {
+		return false
+	}
+	t.injected.WithLabelValues(fault).Inc()
+	return true
+}
+
+func (t *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.happens(faultDelay) {
+		timer := time.NewTimer(rand.N(t.maxDelay))
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+	if t.happens(faultReset) {
+		return nil, errInjectedReset
+	}
+	if t.happens(faultError) {
+		return &http.Response{
+			Status:     fmt.Sprintf("%d %s", t.status, http.StatusText(t.status)),
+			StatusCode: t.status,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{"Content-Type": {"text/plain"}},
+			Body:       io.NopCloser(strings.NewReader("fault injection\n")),
+			Request:    req,
+		}, nil
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	truncate, corrupt := t.happens(faultTruncate), t.happens(faultCorrupt)
+	if !truncate && !corrupt {
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if corrupt && len(body) > 0 {
+		// Garbage in the middle of the document rather than a clean error.
+		copy(body[rand.IntN(len(body)):], `"}{\`)
+	}
+	var next io.Reader = bytes.NewReader(body)
+	if truncate && len(body) > 0 {
+		next = io.MultiReader(bytes.NewReader(body[:rand.IntN(len(body))]), errReader{io.ErrUnexpectedEOF})
+	}
+	resp.Body = io.NopCloser(next)
+	return resp, nil
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+// injectFaults wraps the transport to the Grid with the faults of
+// -fault-injection.
+func injectFaults(next http.RoundTripper) http.RoundTripper {
+	if *faultInjection == "" {
+		return next
+	}
+	t, err := parseFaults(*faultInjection, next)
+	if err != nil {
+		logrus.Fatalf("Failed to parse -fault-injection: %v", err)
+	}
+	logrus.Warnf("Injecting faults into the Grid responses: %s", *faultInjection)
+	prometheus.MustRegister(t.injected)
+	return t
+}
@@ -0,0 +1,10 @@
+//go:build !chaos
+
+package main
+
+import "net/http"
+
+// injectFaults is a no-op outside of builds with the chaos tag.
+func injectFaults(next http.RoundTripper) http.RoundTripper {
+	return next
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wakeful/selenium_grid_exporter/collector"
+)
+
+var readyMaxAge = flag.Duration("ready.max-age", parseDuration(getEnv("READY_MAX_AGE", "5m")), "Maximum age of the last successful scrape of every Grid for /ready to report the exporter ready.")
+
+/*
+newReadyHandler serves the readiness of the exporter: 200 when the last
+successful scrape of every target, or of the one given with ?grid=name, is at
+most maxAge old, 503 with the reasons otherwise. Liveness is served separately
+on /healthz, which only tells that the process answers.
+*/
+func newReadyHandler(maxAge time.Duration, targets *collector.TargetSet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		grid := r.URL.Query().Get("grid")
+
+		found := false
+		var problems []string
+		for _, e := range targets.List() {
+			if grid != "" && e.Name != grid {
+				continue
+			}
+			found = true
+			if err := e.Ready(maxAge); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", e.Name, err))
+			}
+		}
+		if grid != "" && !found {
+			http.Error(w, "unknown grid", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if len(problems) > 0 {
+			logrus.Debugf("Not ready: %s", strings.Join(problems, "; "))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(strings.Join(problems, "\n") + "\n"))
+			return
+		}
+		w.Write([]byte("OK"))
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wakeful/selenium_grid_exporter/collector"
+)
+
+var (
+	instanceID     = flag.String("instance-id", getEnv("INSTANCE_ID", ""), "Identifier of this exporter instance; generated on startup, or read from -instance-id-file, when empty.")
+	instanceIDFile = flag.String("instance-id-file", getEnv("INSTANCE_ID_FILE", ""), "Path to a file keeping the generated instance identifier across restarts.")
+)
+
+/*
+loadInstanceID returns the identifier of this exporter instance: the given one,
+else the one stored in path, else a new random UUID which is stored in path so
+a restarted exporter keeps its identity.
+*/
+func loadInstanceID(id, path string) (string, error) {
+	if id != "" {
+		return id, nil
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err == nil && strings.TrimSpace(string(data)) != "" {
+			return strings.TrimSpace(string(data)), nil
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+	if path != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(path, []byte(id+"\n"), 0o644); err != nil {
+			return "", err
+		}
+	}
+	return id, nil
+}
+
+// newUUID returns a random version 4 UUID.
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+func newInstanceInfo(id string) prometheus.Gauge {
+	info := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   collector.Namespace,
+		Subsystem:   collector.ExporterSubsystem,
+		Name:        "instance_info",
+		Help:        "Identifier of the exporter instance, value is always 1.",
+		ConstLabels: prometheus.Labels{"instance_id": id},
+	})
+	info.Set(1)
+	return info
+}
@@ -0,0 +1,111 @@
+// Package gridmock renders the GraphQL answer of a synthetic Selenium Grid,
+// for the tests of the collector and the bench command of the exporter.
+package gridmock
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// Grid is a Grid 4 of Nodes nodes of Slots slots each, the Busy ratio of
+// which run a session, with Queue session requests waiting. The nodes offer
+// chrome, firefox and MicrosoftEdge in turn.
+type Grid struct {
+	Nodes, Slots, Queue int
+	Busy                float64
+}
+
+// Response returns the answer of the Grid to the full GraphQL query of the
+// exporter.
+func (g Grid) Response() ([]byte, error) {
+	type session struct {
+		Id                    string `json:"id"`
+		NodeId                string `json:"nodeId"`
+		SessionDurationMillis int    `json:"sessionDurationMillis"`
+		Capabilities          string `json:"capabilities"`
+	}
+	type node struct {
+		Id           string            `json:"id"`
+		Uri          string            `json:"uri"`
+		Status       string            `json:"status"`
+		MaxSession   int               `json:"maxSession"`
+		SlotCount    int               `json:"slotCount"`
+		SessionCount int               `json:"sessionCount"`
+		Version      string            `json:"version"`
+		Stereotypes  string            `json:"stereotypes"`
+		OsInfo       map[string]string `json:"osInfo"`
+	}
+
+	browsers := []string{"chrome", "firefox", "MicrosoftEdge"}
+	busy := int(math.Round(g.Busy * float64(g.Slots)))
+	nodes := make([]node, 0, g.Nodes)
+	sessions := []session{}
+	for i := 0; i < g.Nodes; i++ {
+		browser := browsers[i%len(browsers)]
+		stereotypes, err := json.Marshal([]map[string]interface{}{{
+			"slots":      g.Slots,
+			"stereotype": map[string]string{"browserName": browser, "browserVersion": "126.0", "platformName": "linux"},
+		}})
+		if err != nil {
+			return nil, err
+		}
+		n := node{
+			Id:           fmt.Sprintf("node-%05d", i),
+			Uri:          fmt.Sprintf("http://10.%d.%d.%d:5555", i>>16&0xff, i>>8&0xff, i&0xff),
+			Status:       "UP",
+			MaxSession:   g.Slots,
+			SlotCount:    g.Slots,
+			SessionCount: busy,
+			Version:      "4.22.0",
+			Stereotypes:  string(stereotypes),
+			OsInfo:       map[string]string{"arch": "amd64", "name": "Linux", "version": "6.1"},
+		}
+		nodes = append(nodes, n)
+		for j := 0; j < busy; j++ {
+			sessions = append(sessions, session{
+				Id:                    fmt.Sprintf("%s-session-%d", n.Id, j),
+				NodeId:                n.Id,
+				SessionDurationMillis: 1000 * (j + 1),
+				Capabilities:          `{"browserName": "` + browser + `"}`,
+			})
+		}
+	}
+	queue := make([]string, g.Queue)
+	for i := range queue {
+		queue[i] = `{"browserName": "` + browsers[i%len(browsers)] + `"}`
+	}
+
+	var response struct {
+		Data struct {
+			Grid struct {
+				TotalSlots       int    `json:"totalSlots"`
+				MaxSession       int    `json:"maxSession"`
+				SessionCount     int    `json:"sessionCount"`
+				SessionQueueSize int    `json:"sessionQueueSize"`
+				NodeCount        int    `json:"nodeCount"`
+				Version          string `json:"version"`
+				Uri              string `json:"uri"`
+			} `json:"grid"`
+			NodesInfo struct {
+				Nodes []node `json:"nodes"`
+			} `json:"nodesInfo"`
+			SessionsInfo struct {
+				Sessions             []session `json:"sessions"`
+				SessionQueueRequests []string  `json:"sessionQueueRequests"`
+			} `json:"sessionsInfo"`
+		} `json:"data"`
+	}
+	grid := &response.Data.Grid
+	grid.TotalSlots = g.Nodes * g.Slots
+	grid.MaxSession = g.Nodes * g.Slots
+	grid.SessionCount = len(sessions)
+	grid.SessionQueueSize = g.Queue
+	grid.NodeCount = g.Nodes
+	grid.Version = "4.22.0"
+	grid.Uri = "http://selenium-hub.bench:4444"
+	response.Data.NodesInfo.Nodes = nodes
+	response.Data.SessionsInfo.Sessions = sessions
+	response.Data.SessionsInfo.SessionQueueRequests = queue
+	return json.Marshal(response)
+}
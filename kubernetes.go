@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+	kubernetesRoleService = "service"
+	kubernetesRolePod     = "pod"
+)
+
+// kubernetesDiscovery lists Grid hubs through the Kubernetes API, using the
+// credentials of the pod service account.
+type kubernetesDiscovery struct {
+	client *http.Client
+	host   string
+	token  string
+}
+
+type kubernetesMetadata struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+type kubernetesPort struct {
+	Name          string `json:"name"`
+	Port          int    `json:"port"`          // services
+	ContainerPort int    `json:"containerPort"` // pods
+}
+
+type kubernetesServiceList struct {
+	Items []struct {
+		Metadata kubernetesMetadata `json:"metadata"`
+		Spec     struct {
+			Ports []kubernetesPort `json:"ports"`
+		} `json:"spec"`
+	} `json:"items"`
+}
+
+type kubernetesPodList struct {
+	Items []struct {
+		Metadata kubernetesMetadata `json:"metadata"`
+		Spec     struct {
+			Containers []struct {
+				Ports []kubernetesPort `json:"ports"`
+			} `json:"containers"`
+		} `json:"spec"`
+		Status struct {
+			Phase string `json:"phase"`
+			PodIP string `json:"podIP"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+func newKubernetesDiscovery(timeout time.Duration) (*kubernetesDiscovery, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster, KUBERNETES_SERVICE_HOST is not set")
+	}
+
+	token, err := os.ReadFile(filepath.Join(serviceAccountDir, "token"))
+	if err != nil {
+		return nil, fmt.Errorf("reading service account token: %w", err)
+	}
+	ca, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("reading service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no valid certificates found in the service account CA")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &kubernetesDiscovery{
+		client: &http.Client{Timeout: timeout, Transport: transport},
+		host:   "https://" + net.JoinHostPort(host, port),
+		token:  strings.TrimSpace(string(token)),
+	}, nil
+}
+
+func (d *kubernetesDiscovery) list(ctx context.Context, resource, namespace, selector string, into interface{}) error {
+	path := "/api/v1/" + resource
+	if namespace != "" {
+		path = "/api/v1/namespaces/" + url.PathEscape(namespace) + "/" + resource
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", d.host+path+"?labelSelector="+url.QueryEscape(selector), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+d.token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("listing %s: unexpected HTTP status: %s", resource, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(into)
+}
+
+/*
+discover returns a target for every Service (or running Pod) matching the
+label selector, in namespace or in all namespaces when empty. The port is
+picked by name or number, the first one being used when none is given.
+Targets are named namespace/name and labelled with both. Services are scraped
+on their cluster DNS name, Pods on their IP, which watch follows when a Pod is
+replaced.
+*/
+func (d *kubernetesDiscovery) discover(ctx context.Context, role, namespace, selector, port string) ([]gridTarget, error) {
+	var targets []gridTarget
+	target := func(meta kubernetesMetadata, host string, ports []kubernetesPort) {
+		p, ok := pickPort(ports, port)
+		if !ok {
+			return
+		}
+		scheme := "http"
+		if p.Name == "https" {
+			scheme = "https"
+		}
+		number := p.Port
+		if number == 0 {
+			number = p.ContainerPort
+		}
+		targets = append(targets, gridTarget{
+			Name:   meta.Namespace + "/" + meta.Name,
+			URI:    scheme + "://" + net.JoinHostPort(host, strconv.Itoa(number)),
+			Labels: map[string]string{"namespace": meta.Namespace, role: meta.Name},
+		})
+	}
+
+	switch role {
+	case kubernetesRoleService:
+		var services kubernetesServiceList
+		if err := d.list(ctx, "services", namespace, selector, &services); err != nil {
+			return nil, err
+		}
+		for _, s := range services.Items {
+			target(s.Metadata, s.Metadata.Name+"."+s.Metadata.Namespace+".svc", s.Spec.Ports)
+		}
+	case kubernetesRolePod:
+		var pods kubernetesPodList
+		if err := d.list(ctx, "pods", namespace, selector, &pods); err != nil {
+			return nil, err
+		}
+		for _, p := range pods.Items {
+			if p.Status.Phase != "Running" || p.Status.PodIP == "" {
+				continue
+			}
+			var ports []kubernetesPort
+			for _, c := range p.Spec.Containers {
+				ports = append(ports, c.Ports...)
+			}
+			target(p.Metadata, p.Status.PodIP, ports)
+		}
+	default:
+		return nil, fmt.Errorf("unknown role %q, expected service or pod", role)
+	}
+	return targets, nil
+}
+
+/*
+watch lists the Grids again every interval until ctx is done, handing them to
+sync, so Grids deployed or removed after startup are scraped or dropped. A
+failed listing keeps the current targets rather than dropping them all.
+*/
+func (d *kubernetesDiscovery) watch(ctx context.Context, interval time.Duration, role, namespace, selector, port string, sync func([]gridTarget)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		targets, err := d.discover(ctx, role, namespace, selector, port)
+		if err != nil {
+			if ctx.Err() == nil {
+				logrus.Warnf("Failed to discover Grids in Kubernetes, keeping the current ones: %v", err)
+			}
+			continue
+		}
+		sync(targets)
+	}
+}
+
+func pickPort(ports []kubernetesPort, want string) (kubernetesPort, bool) {
+	for _, p := range ports {
+		if want == "" || p.Name == want || strconv.Itoa(p.Port) == want || strconv.Itoa(p.ContainerPort) == want {
+			return p, true
+		}
+	}
+	return kubernetesPort{}, false
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/wakeful/selenium_grid_exporter/collector"
+)
+
+var landingTemplate = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Selenium Grid Exporter</title></head>
+<body>
+<h1>Selenium Grid Exporter</h1>
+<p>Welcome to Selenium Grid Exporter! Metrics are available at <a href="{{.MetricsPath}}">{{.MetricsPath}}</a></p>
+<p>Version {{.Version}} ({{.GitCommit}})</p>
+{{range .Targets}}
+<h2>{{.URI}}</h2>
+<table>
+<tr><th align="left">Status</th><td>{{.Status}}</td></tr>
+<tr><th align="left">Last successful scrape</th><td>{{.LastSuccess}}</td></tr>
+<tr><th align="left">Last scrape error</th><td>{{.LastError}}</td></tr>
+<tr><th align="left">Last scrape duration</th><td>{{.Duration}}</td></tr>
+<tr><th align="left">Scrape mode</th><td>{{.Mode}}</td></tr>
+<tr><th align="left">API</th><td>{{.API}}</td></tr>
+<tr><th align="left">HTTP timeout</th><td>{{.Timeout}}</td></tr>
+<tr><th align="left">Retries</th><td>{{.Retries}}</td></tr>
+<tr><th align="left">Node status scraping</th><td>{{.NodeStatus}}</td></tr>
+</table>
+{{end}}
+</body>
+</html>
+`))
+
+type landingTarget struct {
+	URI, Status, LastSuccess, LastError, Duration, Mode, API, Timeout, NodeStatus string
+	Retries                                                                       int
+}
+
+// newLandingPage serves a summary of every target, so the reason for missing
+// data is visible without access to the logs.
+func newLandingPage(metricsPath string, targets *collector.TargetSet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		data := struct {
+			MetricsPath, Version, GitCommit string
+			Targets                         []landingTarget
+		}{MetricsPath: metricsPath, Version: version, GitCommit: gitCommit}
+		for _, e := range targets.List() {
+			data.Targets = append(data.Targets, newLandingTarget(e.Status()))
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := landingTemplate.Execute(w, data); err != nil {
+			logrus.Errorf("Failed to render landing page: %v", err)
+		}
+	})
+}
+
+func newLandingTarget(status collector.Status) landingTarget {
+	t := landingTarget{
+		URI:         status.URI,
+		Status:      "not scraped yet",
+		LastSuccess: "never",
+		LastError:   "none",
+		Duration:    "-",
+		Mode:        "on demand",
+		API:         status.API,
+		Timeout:     status.Timeout.String(),
+		Retries:     status.Retries,
+		NodeStatus:  "disabled",
+	}
+	if status.ScrapeInterval > 0 {
+		t.Mode = "every " + status.ScrapeInterval.String()
+	}
+	if status.NodeStatusInterval > 0 {
+		t.NodeStatus = "every " + status.NodeStatusInterval.String()
+	}
+	if !status.Scraped {
+		return t
+	}
+
+	t.Status = "down"
+	if status.Up {
+		t.Status = "up"
+	}
+	if !status.LastSuccess.IsZero() {
+		t.LastSuccess = formatAgo(status.LastSuccess)
+	}
+	if status.LastError != "" {
+		t.LastError = formatAgo(status.LastErrorAt) + ": " + status.LastError
+	}
+	t.Duration = status.Duration.Round(time.Millisecond).String()
+	if status.API == collector.APIAuto && status.UsedAPI != "" {
+		t.API += " (using " + status.UsedAPI + ")"
+	}
+	return t
+}
+
+func formatAgo(t time.Time) string {
+	return t.Format(time.RFC3339) + " (" + time.Since(t).Round(time.Second).String() + " ago)"
+}
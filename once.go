@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/sirupsen/logrus"
+
+	"github.com/wakeful/selenium_grid_exporter/collector"
+)
+
+var (
+	onceFlag   = flag.Bool("once", parseBool(getEnv("ONCE", "false")), "Scrape the Grids once, write the metrics to -once.output and exit, with status 1 when a Grid can't be scraped.")
+	onceFormat = flag.String("once.format", getEnv("ONCE_FORMAT", "text"), "Format of the -once output: text, openmetrics or json.")
+	onceOutput = flag.String("once.output", getEnv("ONCE_OUTPUT", ""), "File the -once output is written to, replaced atomically so the node_exporter textfile collector never reads a partial one; stdout when empty.")
+)
+
+// Sample of the JSON output of -once.
+type onceSample struct {
+	Labels  map[string]string `json:"labels,omitempty"`
+	Value   *float64          `json:"value,omitempty"`
+	Count   *uint64           `json:"count,omitempty"`
+	Sum     *float64          `json:"sum,omitempty"`
+	Buckets map[string]uint64 `json:"buckets,omitempty"`
+}
+
+type onceFamily struct {
+	Name    string       `json:"name"`
+	Help    string       `json:"help"`
+	Type    string       `json:"type"`
+	Samples []onceSample `json:"samples"`
+}
+
+/*
+runOnce gathers the metrics of a single scrape of every target and writes them
+in format to path, or stdout. It returns the exit status: 1 when a target could
+not be scraped or the metrics could not be written.
+*/
+func runOnce(gatherer prometheus.Gatherer, format, path string, targets ...*collector.Collector) int {
+	families, err := gatherer.Gather()
+	if err != nil {
+		logrus.Errorf("Failed to gather metrics: %v", err)
+		return 1
+	}
+
+	var out bytes.Buffer
+	if err := writeFamilies(&out, format, families); err != nil {
+		logrus.Errorf("Failed to encode metrics: %v", err)
+		return 1
+	}
+	if path == "" {
+		_, err = os.Stdout.Write(out.Bytes())
+	} else {
+		err = writeFileAtomic(path, out.Bytes())
+	}
+	if err != nil {
+		logrus.Errorf("Failed to write metrics: %v", err)
+		return 1
+	}
+
+	status := 0
+	for _, e := range targets {
+		if s := e.Status(); !s.Up {
+			logrus.Errorf("Selenium Grid %s is down: %s", e.Name, s.LastError)
+			status = 1
+		}
+	}
+	return status
+}
+
+func writeFamilies(w io.Writer, format string, families []*dto.MetricFamily) error {
+	switch format {
+	case "json":
+		return json.NewEncoder(w).Encode(onceFamilies(families))
+	case "text", "openmetrics":
+		expFormat := expfmt.FmtText
+		if format == "openmetrics" {
+			expFormat = expfmt.FmtOpenMetrics_1_0_0
+		}
+		enc := expfmt.NewEncoder(w, expFormat)
+		for _, family := range families {
+			if err := enc.Encode(family); err != nil {
+				return err
+			}
+		}
+		if closer, ok := enc.(expfmt.Closer); ok {
+			return closer.Close()
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown format %q, expected text, openmetrics or json", format)
+}
+
+func onceFamilies(families []*dto.MetricFamily) []onceFamily {
+	out := make([]onceFamily, 0, len(families))
+	for _, family := range families {
+		f := onceFamily{Name: family.GetName(), Help: family.GetHelp(), Type: family.GetType().String()}
+		for _, m := range family.GetMetric() {
+			s := onceSample{Labels: map[string]string{}}
+			for _, l := range m.GetLabel() {
+				s.Labels[l.GetName()] = l.GetValue()
+			}
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				s.Value = m.GetCounter().Value
+			case dto.MetricType_GAUGE:
+				s.Value = m.GetGauge().Value
+			case dto.MetricType_UNTYPED:
+				s.Value = m.GetUntyped().Value
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				s.Count, s.Sum = h.SampleCount, h.SampleSum
+				s.Buckets = map[string]uint64{"+Inf": h.GetSampleCount()}
+				for _, b := range h.GetBucket() {
+					s.Buckets[strconv.FormatFloat(b.GetUpperBound(), 'f', -1, 64)] = b.GetCumulativeCount()
+				}
+			case dto.MetricType_SUMMARY:
+				s.Count, s.Sum = m.GetSummary().SampleCount, m.GetSummary().SampleSum
+			}
+			f.Samples = append(f.Samples, s)
+		}
+		out = append(out, f)
+	}
+	return out
+}
+
+// writeFileAtomic replaces path with data through a temporary file in the same
+// directory.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
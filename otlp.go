@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+
+	"github.com/wakeful/selenium_grid_exporter/collector"
+)
+
+// OTLP aggregation temporality of Prometheus counters and histograms.
+const otlpCumulative = 2
+
+// outboundDestinationOTLP accounts the pushes in the outbound request metrics.
+const outboundDestinationOTLP = "otlp"
+
+/*
+otlpPusher periodically gathers the exporter metrics and pushes them to an
+OpenTelemetry collector as OTLP/HTTP with JSON encoding, for environments where
+the collector cannot scrape /metrics.
+*/
+type otlpPusher struct {
+	endpoint string
+	headers  map[string]string
+	interval time.Duration
+	client   *http.Client
+	gatherer prometheus.Gatherer
+	start    time.Time
+
+	pushes *prometheus.CounterVec
+}
+
+// Subset of the OTLP metrics data model, in its protobuf JSON mapping: 64 bit
+// integers are encoded as strings, non-finite doubles as otlpDouble.
+type (
+	otlpRequest struct {
+		ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+	}
+	otlpResourceMetrics struct {
+		Resource struct {
+			Attributes []otlpAttribute `json:"attributes"`
+		} `json:"resource"`
+		ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+	}
+	otlpScopeMetrics struct {
+		Scope struct {
+			Name    string `json:"name"`
+			Version string `json:"version,omitempty"`
+		} `json:"scope"`
+		Metrics []otlpMetric `json:"metrics"`
+	}
+	otlpMetric struct {
+		Name        string         `json:"name"`
+		Description string         `json:"description,omitempty"`
+		Gauge       *otlpGauge     `json:"gauge,omitempty"`
+		Sum         *otlpSum       `json:"sum,omitempty"`
+		Histogram   *otlpHistogram `json:"histogram,omitempty"`
+	}
+	otlpGauge struct {
+		DataPoints []otlpNumberDataPoint `json:"dataPoints"`
+	}
+	otlpSum struct {
+		DataPoints             []otlpNumberDataPoint `json:"dataPoints"`
+		AggregationTemporality int                   `json:"aggregationTemporality"`
+		IsMonotonic            bool                  `json:"isMonotonic"`
+	}
+	otlpHistogram struct {
+		DataPoints             []otlpHistogramDataPoint `json:"dataPoints"`
+		AggregationTemporality int                      `json:"aggregationTemporality"`
+	}
+	otlpNumberDataPoint struct {
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+		TimeUnixNano      string          `json:"timeUnixNano"`
+		AsDouble          otlpDouble      `json:"asDouble"`
+	}
+	otlpHistogramDataPoint struct {
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		TimeUnixNano      string          `json:"timeUnixNano"`
+		Count             string          `json:"count"`
+		Sum               otlpDouble      `json:"sum"`
+		BucketCounts      []string        `json:"bucketCounts"`
+		ExplicitBounds    []float64       `json:"explicitBounds"`
+	}
+	otlpAttribute struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+		} `json:"value"`
+	}
+)
+
+/*
+otlpDouble is a double of the protobuf JSON mapping, which encodes NaN and the
+infinities as the strings "NaN", "Infinity" and "-Infinity" where JSON numbers
+cannot hold them, e.g. for a gauge set to NaN.
+*/
+type otlpDouble float64
+
+func (d otlpDouble) MarshalJSON() ([]byte, error) {
+	switch v := float64(d); {
+	case math.IsNaN(v):
+		return []byte(`"NaN"`), nil
+	case math.IsInf(v, 1):
+		return []byte(`"Infinity"`), nil
+	case math.IsInf(v, -1):
+		return []byte(`"-Infinity"`), nil
+	default:
+		return json.Marshal(v)
+	}
+}
+
+// parseHeaders parses a comma separated list of key=value pairs.
+func parseHeaders(value string) (map[string]string, error) {
+	headers := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid header %q, expected key=value", pair)
+		}
+		headers[strings.TrimSpace(key)] = strings.TrimSpace(val)
+	}
+	return headers, nil
+}
+
+func newOTLPPusher(endpoint string, headers map[string]string, interval time.Duration, client *http.Client, gatherer prometheus.Gatherer) *otlpPusher {
+	return &otlpPusher{
+		endpoint: strings.TrimSuffix(endpoint, "/") + "/v1/metrics",
+		headers:  headers,
+		interval: interval,
+		client:   client,
+		gatherer: gatherer,
+		start:    time.Now(),
+		pushes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: collector.Namespace,
+			Subsystem: collector.ExporterSubsystem,
+			Name:      "otlp_pushes_total",
+			Help:      "Number of OTLP metric pushes by result.",
+		}, []string{"result"}),
+	}
+}
+
+// run pushes the metrics once right away, then every interval until ctx is
+// done.
+func (p *otlpPusher) run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.push(ctx); err != nil {
+			logrus.Warnf("Failed to push metrics to %s: %v", collector.RedactURI(p.endpoint), err)
+			p.pushes.WithLabelValues("error").Inc()
+		} else {
+			p.pushes.WithLabelValues("success").Inc()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *otlpPusher) push(ctx context.Context) error {
+	families, err := p.gatherer.Gather()
+	if err != nil {
+		// Gather returns whatever it could collect alongside the error.
+		logrus.Warnf("Error gathering metrics for OTLP push: %v", err)
+	}
+
+	body, err := json.Marshal(p.request(families, time.Now()))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range p.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected HTTP status: %s", resp.Status)
+	}
+	return nil
+}
+
+// request converts the Selenium metrics of the registry to OTLP. Prometheus
+// histograms carry cumulative buckets, OTLP expects a count per bucket.
+func (p *otlpPusher) request(families []*dto.MetricFamily, now time.Time) otlpRequest {
+	scope := otlpScopeMetrics{}
+	scope.Scope.Name = "selenium_grid_exporter"
+	scope.Scope.Version = version
+
+	timestamp := strconv.FormatInt(now.UnixNano(), 10)
+	start := strconv.FormatInt(p.start.UnixNano(), 10)
+
+	for _, family := range families {
+		if !strings.HasPrefix(family.GetName(), collector.Namespace+"_") {
+			continue
+		}
+		metric := otlpMetric{Name: family.GetName(), Description: family.GetHelp()}
+
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			metric.Sum = &otlpSum{AggregationTemporality: otlpCumulative, IsMonotonic: true}
+			for _, m := range family.GetMetric() {
+				metric.Sum.DataPoints = append(metric.Sum.DataPoints, otlpNumberDataPoint{
+					Attributes:        otlpAttributes(m),
+					StartTimeUnixNano: start,
+					TimeUnixNano:      timestamp,
+					AsDouble:          otlpDouble(m.GetCounter().GetValue()),
+				})
+			}
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			metric.Gauge = &otlpGauge{}
+			for _, m := range family.GetMetric() {
+				value := m.GetGauge().GetValue()
+				if family.GetType() == dto.MetricType_UNTYPED {
+					value = m.GetUntyped().GetValue()
+				}
+				metric.Gauge.DataPoints = append(metric.Gauge.DataPoints, otlpNumberDataPoint{
+					Attributes:   otlpAttributes(m),
+					TimeUnixNano: timestamp,
+					AsDouble:     otlpDouble(value),
+				})
+			}
+		case dto.MetricType_HISTOGRAM:
+			metric.Histogram = &otlpHistogram{AggregationTemporality: otlpCumulative}
+			for _, m := range family.GetMetric() {
+				h := m.GetHistogram()
+				point := otlpHistogramDataPoint{
+					Attributes:        otlpAttributes(m),
+					StartTimeUnixNano: start,
+					TimeUnixNano:      timestamp,
+					Count:             strconv.FormatUint(h.GetSampleCount(), 10),
+					Sum:               otlpDouble(h.GetSampleSum()),
+				}
+				var previous uint64
+				for _, b := range h.GetBucket() {
+					if math.IsInf(b.GetUpperBound(), 1) {
+						continue
+					}
+					point.ExplicitBounds = append(point.ExplicitBounds, b.GetUpperBound())
+					point.BucketCounts = append(point.BucketCounts, strconv.FormatUint(b.GetCumulativeCount()-previous, 10))
+					previous = b.GetCumulativeCount()
+				}
+				point.BucketCounts = append(point.BucketCounts, strconv.FormatUint(h.GetSampleCount()-previous, 10))
+				metric.Histogram.DataPoints = append(metric.Histogram.DataPoints, point)
+			}
+		default:
+			continue
+		}
+		scope.Metrics = append(scope.Metrics, metric)
+	}
+
+	resource := otlpResourceMetrics{ScopeMetrics: []otlpScopeMetrics{scope}}
+	resource.Resource.Attributes = []otlpAttribute{
+		otlpAttr("service.name", "selenium_grid_exporter"),
+		otlpAttr("service.version", version),
+	}
+	return otlpRequest{ResourceMetrics: []otlpResourceMetrics{resource}}
+}
+
+func otlpAttributes(m *dto.Metric) []otlpAttribute {
+	attributes := make([]otlpAttribute, 0, len(m.GetLabel()))
+	for _, l := range m.GetLabel() {
+		attributes = append(attributes, otlpAttr(l.GetName(), l.GetValue()))
+	}
+	return attributes
+}
+
+func otlpAttr(key, value string) otlpAttribute {
+	a := otlpAttribute{Key: key}
+	a.Value.StringValue = value
+	return a
+}
+
+func (p *otlpPusher) Describe(ch chan<- *prometheus.Desc) {
+	p.pushes.Describe(ch)
+}
+
+func (p *otlpPusher) Collect(ch chan<- prometheus.Metric) {
+	p.pushes.Collect(ch)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/wakeful/selenium_grid_exporter/collector"
+)
+
+func TestOTLPPushNonFinite(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Namespace: collector.Namespace, Name: "ratio"}, []string{"case"})
+	reg.MustRegister(gauge)
+	gauge.WithLabelValues("nan").Set(math.NaN())
+	gauge.WithLabelValues("inf").Set(math.Inf(1))
+	gauge.WithLabelValues("-inf").Set(math.Inf(-1))
+	gauge.WithLabelValues("finite").Set(0.5)
+
+	bodies := make(chan string, 1)
+	otel := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		select {
+		case bodies <- string(body):
+		default:
+		}
+	}))
+	defer otel.Close()
+
+	// The first push happens right away, not after an interval.
+	p := newOTLPPusher(otel.URL, nil, time.Hour, otel.Client(), reg)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.run(ctx)
+
+	var body string
+	select {
+	case body = <-bodies:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no push at startup")
+	}
+	if !json.Valid([]byte(body)) {
+		t.Fatalf("pushed invalid JSON: %s", body)
+	}
+	for _, want := range []string{`"asDouble":"NaN"`, `"asDouble":"Infinity"`, `"asDouble":"-Infinity"`, `"asDouble":0.5`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("push lacks %s: %s", want, body)
+		}
+	}
+}
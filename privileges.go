@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// hardening holds the runtime restrictions applied once the listen port is bound.
+type hardening struct {
+	user, group, chroot, umask string
+	allowRoot                  bool
+}
+
+// resolveIDs looks up the numeric user and group IDs to switch to. This has to
+// happen before a chroot, which usually hides /etc/passwd and /etc/group.
+func (h hardening) resolveIDs() (uid, gid int, err error) {
+	uid, gid = -1, -1
+
+	if h.user != "" {
+		u, err := lookupUser(h.user)
+		if err != nil {
+			return 0, 0, err
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return 0, 0, fmt.Errorf("user %s has no numeric uid: %w", h.user, err)
+		}
+		if gid, err = strconv.Atoi(u.Gid); err != nil {
+			return 0, 0, fmt.Errorf("user %s has no numeric gid: %w", h.user, err)
+		}
+	}
+
+	if h.group != "" {
+		g, err := user.LookupGroupId(h.group)
+		if err != nil {
+			if g, err = user.LookupGroup(h.group); err != nil {
+				return 0, 0, fmt.Errorf("looking up group %s: %w", h.group, err)
+			}
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return 0, 0, fmt.Errorf("group %s has no numeric gid: %w", h.group, err)
+		}
+	}
+	return uid, gid, nil
+}
+
+func lookupUser(name string) (*user.User, error) {
+	if u, err := user.LookupId(name); err == nil {
+		return u, nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("looking up user %s: %w", name, err)
+	}
+	return u, nil
+}
+
+// apply restricts the process: chroot, drop to the configured user and group,
+// set the umask, and finally refuse to keep running as root unless allowed.
+func (h hardening) apply() error {
+	uid, gid, err := h.resolveIDs()
+	if err != nil {
+		return err
+	}
+
+	if h.chroot != "" {
+		if err := chroot(h.chroot); err != nil {
+			return fmt.Errorf("chroot to %s: %w", h.chroot, err)
+		}
+	}
+	if err := setIDs(uid, gid); err != nil {
+		return err
+	}
+	if h.umask != "" {
+		mask, err := strconv.ParseUint(h.umask, 8, 32)
+		if err != nil {
+			return fmt.Errorf("invalid umask %q: %w", h.umask, err)
+		}
+		if err := setUmask(int(mask)); err != nil {
+			return err
+		}
+	}
+
+	if os.Geteuid() == 0 && !h.allowRoot {
+		return fmt.Errorf("refusing to run as root, use -run-as-user or pass -allow-root")
+	}
+	return nil
+}
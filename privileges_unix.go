@@ -0,0 +1,38 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+func chroot(dir string) error {
+	if err := syscall.Chroot(dir); err != nil {
+		return err
+	}
+	return syscall.Chdir("/")
+}
+
+// setIDs drops to the given group and user; -1 leaves an ID unchanged.
+func setIDs(uid, gid int) error {
+	if gid >= 0 {
+		if err := syscall.Setgroups([]int{gid}); err != nil {
+			return fmt.Errorf("setgroups: %w", err)
+		}
+		if err := syscall.Setgid(gid); err != nil {
+			return fmt.Errorf("setgid %d: %w", gid, err)
+		}
+	}
+	if uid >= 0 {
+		if err := syscall.Setuid(uid); err != nil {
+			return fmt.Errorf("setuid %d: %w", uid, err)
+		}
+	}
+	return nil
+}
+
+func setUmask(mask int) error {
+	syscall.Umask(mask)
+	return nil
+}
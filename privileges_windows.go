@@ -0,0 +1,22 @@
+//go:build windows
+
+package main
+
+import "errors"
+
+var errUnsupported = errors.New("not supported on windows")
+
+func chroot(string) error {
+	return errUnsupported
+}
+
+func setIDs(uid, gid int) error {
+	if uid >= 0 || gid >= 0 {
+		return errUnsupported
+	}
+	return nil
+}
+
+func setUmask(int) error {
+	return errUnsupported
+}
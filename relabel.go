@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	relabelDrop    = "drop"
+	relabelReplace = "replace"
+	relabelHost    = "host"
+	relabelHash    = "hash"
+)
+
+/*
+relabelRule rewrites a label of the exported metrics, optionally only for the
+metrics whose name matches Metrics:
+
+  - drop removes the label,
+  - replace substitutes Replacement when the value matches Regex, with $1
+    style references to its groups,
+  - host keeps only the host of a URI,
+  - hash replaces the value by a short hash of it.
+
+A label left empty is removed, as Prometheus treats both the same.
+*/
+type relabelRule struct {
+	Label       string `yaml:"label"`
+	Action      string `yaml:"action"`
+	Metrics     string `yaml:"metrics"`
+	Regex       string `yaml:"regex"`
+	Replacement string `yaml:"replacement"`
+
+	metrics, regex *regexp.Regexp
+}
+
+func (r *relabelRule) compile() error {
+	if r.Label == "" {
+		return fmt.Errorf("relabel rule needs a label")
+	}
+	switch r.Action {
+	case relabelDrop, relabelHost, relabelHash:
+	case relabelReplace:
+		re, err := regexp.Compile("^(?:" + r.Regex + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid relabel regex %q: %w", r.Regex, err)
+		}
+		r.regex = re
+	default:
+		return fmt.Errorf("unknown relabel action %q for label %s, expected drop, replace, host or hash", r.Action, r.Label)
+	}
+	if r.Metrics != "" {
+		re, err := regexp.Compile("^(?:" + r.Metrics + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid relabel metrics pattern %q: %w", r.Metrics, err)
+		}
+		r.metrics = re
+	}
+	return nil
+}
+
+func (r *relabelRule) apply(value string) string {
+	switch r.Action {
+	case relabelDrop:
+		return ""
+	case relabelHost:
+		if u, err := url.Parse(value); err == nil && u.Hostname() != "" {
+			return u.Hostname()
+		}
+		return value
+	case relabelHash:
+		sum := sha256.Sum256([]byte(value))
+		return hex.EncodeToString(sum[:])[:12]
+	default:
+		if m := r.regex.FindStringSubmatchIndex(value); m != nil {
+			return string(r.regex.ExpandString(nil, r.Replacement, value, m))
+		}
+		return value
+	}
+}
+
+/*
+relabelGatherer applies the relabel rules to everything gathered, so the
+metrics endpoint and the OTLP push see the same series. Counters which become
+identical are summed. For the other types no merge is right, e.g. summing two
+ratios or keeping one of two histograms, so the series which collide are left
+out and the collision is logged once per metric.
+*/
+type relabelGatherer struct {
+	next  prometheus.Gatherer
+	rules []relabelRule
+
+	mu     sync.Mutex
+	warned map[string]bool
+}
+
+func (g *relabelGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.next.Gather()
+	for _, family := range families {
+		metrics := family.Metric[:0]
+		seen := map[string]*dto.Metric{}
+		collided := map[string]bool{}
+		for _, m := range family.Metric {
+			m.Label = g.relabel(family.GetName(), m.Label)
+			key := labelsKey(m.Label)
+			if first, ok := seen[key]; ok {
+				if family.GetType() == dto.MetricType_COUNTER {
+					value := first.Counter.GetValue() + m.Counter.GetValue()
+					first.Counter.Value = &value
+				} else {
+					collided[key] = true
+				}
+				continue
+			}
+			seen[key] = m
+			metrics = append(metrics, m)
+		}
+
+		if len(collided) > 0 {
+			kept := metrics[:0]
+			for _, m := range metrics {
+				if !collided[labelsKey(m.Label)] {
+					kept = append(kept, m)
+				}
+			}
+			metrics = kept
+			g.warnCollision(family)
+		}
+		family.Metric = metrics
+	}
+	return families, err
+}
+
+func (g *relabelGatherer) warnCollision(family *dto.MetricFamily) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.warned[family.GetName()] {
+		return
+	}
+	if g.warned == nil {
+		g.warned = map[string]bool{}
+	}
+	g.warned[family.GetName()] = true
+	logrus.Warnf("Relabel rules make series of the %s %s identical, leaving them out", strings.ToLower(family.GetType().String()), family.GetName())
+}
+
+func (g *relabelGatherer) relabel(name string, labels []*dto.LabelPair) []*dto.LabelPair {
+	for i := range g.rules {
+		rule := &g.rules[i]
+		if rule.metrics != nil && !rule.metrics.MatchString(name) {
+			continue
+		}
+		for _, l := range labels {
+			if l.GetName() == rule.Label {
+				value := rule.apply(l.GetValue())
+				l.Value = &value
+			}
+		}
+	}
+
+	kept := labels[:0]
+	for _, l := range labels {
+		if l.GetValue() != "" {
+			kept = append(kept, l)
+		}
+	}
+	return kept
+}
+
+func labelsKey(labels []*dto.LabelPair) string {
+	var b strings.Builder
+	for _, l := range labels {
+		b.WriteString(l.GetName())
+		b.WriteByte(0)
+		b.WriteString(l.GetValue())
+		b.WriteByte(0)
+	}
+	return b.String()
+}
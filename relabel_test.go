@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestRelabelCollisions(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "sessions_total"}, []string{"node", "host"})
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "slots_utilization"}, []string{"node", "host"})
+	histogram := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "session_duration_seconds"}, []string{"node", "host"})
+	summary := prometheus.NewSummaryVec(prometheus.SummaryOpts{Name: "request_duration_seconds"}, []string{"node", "host"})
+	reg.MustRegister(counter, gauge, histogram, summary)
+	for node, value := range map[string]float64{"a": 1, "b": 2} {
+		counter.WithLabelValues(node, "shared").Add(value)
+		gauge.WithLabelValues(node, "shared").Set(value / 4)
+		histogram.WithLabelValues(node, "shared").Observe(value)
+		summary.WithLabelValues(node, "shared").Observe(value)
+	}
+	counter.WithLabelValues("c", "other").Add(5)
+	gauge.WithLabelValues("c", "other").Set(1)
+	histogram.WithLabelValues("c", "other").Observe(5)
+	summary.WithLabelValues("c", "other").Observe(5)
+
+	rules := []relabelRule{{Label: "node", Action: relabelDrop}}
+	if err := rules[0].compile(); err != nil {
+		t.Fatal(err)
+	}
+	families, err := (&relabelGatherer{next: reg, rules: rules}).Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string][]*dto.Metric{}
+	for _, f := range families {
+		got[f.GetName()] = f.Metric
+	}
+	for _, tc := range []struct {
+		name string
+		want map[string]float64
+		get  func(*dto.Metric) float64
+	}{
+		{"sessions_total", map[string]float64{"shared": 3, "other": 5}, func(m *dto.Metric) float64 { return m.GetCounter().GetValue() }},
+		{"slots_utilization", map[string]float64{"other": 1}, func(m *dto.Metric) float64 { return m.GetGauge().GetValue() }},
+		{"session_duration_seconds", map[string]float64{"other": 5}, func(m *dto.Metric) float64 { return m.GetHistogram().GetSampleSum() }},
+		{"request_duration_seconds", map[string]float64{"other": 5}, func(m *dto.Metric) float64 { return m.GetSummary().GetSampleSum() }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			metrics := got[tc.name]
+			if len(metrics) != len(tc.want) {
+				t.Fatalf("got %d series, want %d", len(metrics), len(tc.want))
+			}
+			for _, m := range metrics {
+				if len(m.Label) != 1 || m.Label[0].GetName() != "host" {
+					t.Fatalf("got labels %v, want host only", m.Label)
+				}
+				host := m.Label[0].GetValue()
+				if want, ok := tc.want[host]; !ok || tc.get(m) != want {
+					t.Errorf("got %v for host %s, want %v", tc.get(m), host, tc.want)
+				}
+			}
+		})
+	}
+}
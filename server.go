@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/exporter-toolkit/web"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+serve runs the HTTP server on listener until ctx is cancelled (SIGINT/SIGTERM),
+then stops accepting connections and gives in-flight requests up to
+gracePeriod to finish. TLS and basic authentication are configured through the
+exporter-toolkit web configuration file, when one is given.
+*/
+func serve(ctx context.Context, listener net.Listener, server *http.Server, webConfigFile string, gracePeriod time.Duration) error {
+	flags := &web.FlagConfig{WebConfigFile: &webConfigFile}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- web.Serve(listener, server, flags, slog.New(logrusHandler{}))
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	logrus.Infof("Shutting down, waiting up to %s for in-flight requests", gracePeriod)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// logrusHandler forwards the exporter-toolkit slog output to logrus.
+type logrusHandler struct {
+	fields logrus.Fields
+}
+
+func (h logrusHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return logrus.IsLevelEnabled(logrusLevel(level))
+}
+
+func (h logrusHandler) Handle(_ context.Context, r slog.Record) error {
+	fields := logrus.Fields{}
+	for k, v := range h.fields {
+		fields[k] = v
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		fields[a.Key] = a.Value.Any()
+		return true
+	})
+	logrus.WithFields(fields).Log(logrusLevel(r.Level), r.Message)
+	return nil
+}
+
+func (h logrusHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := logrus.Fields{}
+	for k, v := range h.fields {
+		fields[k] = v
+	}
+	for _, a := range attrs {
+		fields[a.Key] = a.Value.Any()
+	}
+	return logrusHandler{fields: fields}
+}
+
+func (h logrusHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+func logrusLevel(level slog.Level) logrus.Level {
+	switch {
+	case level >= slog.LevelError:
+		return logrus.ErrorLevel
+	case level >= slog.LevelWarn:
+		return logrus.WarnLevel
+	case level >= slog.LevelInfo:
+		return logrus.InfoLevel
+	default:
+		return logrus.DebugLevel
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/wakeful/selenium_grid_exporter/collector"
+)
+
+// gridTarget is a Selenium Grid scraped by the exporter.
+type gridTarget struct {
+	Name string `yaml:"name"`
+	URI  string `yaml:"uri"`
+	// GridVersion overrides -grid-version, so one exporter scrapes Grid 3
+	// and Grid 4 hubs during a migration.
+	GridVersion int `yaml:"grid_version"`
+
+	// Components are the servers of a Grid running in distributed mode.
+	Components []collector.Component `yaml:"components"`
+
+	// Labels are added to every metric of the target, e.g. the namespace and
+	// service of discovered targets. All targets must use the same names.
+	Labels map[string]string `yaml:"-"`
+}
+
+// defaultGridName derives a grid label from the scrape URI: the host without
+// scheme and port, so dashboards don't show (or leak) full URLs.
+func defaultGridName(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Hostname() == "" {
+		return uri
+	}
+	return u.Hostname()
+}
+
+// startTarget registers the collector of a single Grid and starts its
+// background loops.
+func startTarget(ctx context.Context, t gridTarget, instanceID string, customFields []collector.CustomField, transport http.RoundTripper, outbound *collector.OutboundManager, maintenance *collector.MaintenanceSchedule, nodeMaintenance *collector.NodeMaintenance, anonymizer *collector.Anonymizer) *collector.Collector {
+	opts := collector.Options{
+		Name:            t.Name,
+		URI:             t.URI,
+		Labels:          t.Labels,
+		InstanceID:      instanceID,
+		Anonymizer:      anonymizer,
+		Transport:       transport,
+		Outbound:        outbound,
+		Timeout:         *httpTimeout,
+		Retries:         *scrapeRetries,
+		RetryBackoff:    *scrapeBackoff,
+		API:             targetAPI(t),
+		ScrapeInterval:  *scrapeInterval,
+		Context:         ctx,
+		SkipNodes:       !*queryNodes,
+		SkipSessions:    !*querySessions,
+		SkipQueue:       !*queryQueue,
+		Introspect:      *queryIntrospection,
+		Scaler:          *scalerEnabled,
+		SessionFeatures: *sessionFeaturesFlag,
+		HoldAnomalous:   *holdAnomalous,
+		ServeStaleFor:   *serveStaleFor,
+		SkipNodeMetrics: !*nodeMetrics,
+		NodeTop:         *nodeTop,
+		NodeMaintenance: nodeMaintenance,
+		Maintenance:     maintenance,
+		CustomFields:    customFields,
+		Components:      t.Components,
+
+		ComponentInterval: *componentStatusInterval,
+	}
+	if *adaptiveEnabled {
+		opts.AdaptiveTimeout = &collector.AdaptiveTimeoutOptions{Percentile: *adaptivePercentile, Multiplier: *adaptiveMultiplier, Min: *adaptiveMinTimeout}
+	}
+	if *nodeStatusEnabled {
+		opts.NodeStatus = &collector.NodeStatusOptions{Interval: *nodeStatusInterval, Rate: *nodeStatusRate}
+	}
+	if *sessionProbeEnabled {
+		opts.SessionProbeInterval = *sessionProbeInterval
+	}
+
+	c := collector.NewCollector(opts)
+	prometheus.MustRegister(c)
+	return c
+}
+
+/*
+runningTargets are the collectors of the scraped Grids by target name. sync
+starts the collectors of new targets and stops, unregisters and removes from
+the served set those of the targets gone or changed, so discovered Grids are
+followed without restarting the exporter.
+*/
+type runningTargets struct {
+	set     *collector.TargetSet
+	start   func(ctx context.Context, t gridTarget) *collector.Collector
+	running map[string]runningTarget
+}
+
+type runningTarget struct {
+	target    gridTarget
+	collector *collector.Collector
+	stop      context.CancelFunc
+}
+
+func newRunningTargets(start func(ctx context.Context, t gridTarget) *collector.Collector) *runningTargets {
+	return &runningTargets{
+		set:     collector.NewTargetSet(),
+		start:   start,
+		running: make(map[string]runningTarget),
+	}
+}
+
+func (r *runningTargets) sync(ctx context.Context, targets []gridTarget) {
+	wanted := make(map[string]gridTarget, len(targets))
+	for _, t := range targets {
+		wanted[t.Name] = t
+	}
+	for name, rt := range r.running {
+		if t, ok := wanted[name]; ok && reflect.DeepEqual(t, rt.target) {
+			continue
+		}
+		logrus.Infof("Stopping the scrapes of Grid %q", rt.collector.Name)
+		rt.stop()
+		prometheus.Unregister(rt.collector)
+		r.set.Remove(rt.collector)
+		delete(r.running, name)
+	}
+
+	for _, t := range targets {
+		if _, ok := r.running[t.Name]; ok {
+			continue
+		}
+		ctx, stop := context.WithCancel(ctx)
+		c := r.start(ctx, t)
+		r.running[t.Name] = runningTarget{target: t, collector: c, stop: stop}
+		r.set.Add(c)
+	}
+}
+
+// targetAPI returns the API to scrape the target through: the Grid 3 hub API
+// for Grid 3, -api otherwise.
+func targetAPI(t gridTarget) string {
+	gridVersion := *gridVersionFlag
+	if t.GridVersion != 0 {
+		gridVersion = t.GridVersion
+	}
+	switch {
+	case gridVersion == 3:
+		return collector.APIGrid3
+	case *apiMode == collector.APIGrid3 && t.GridVersion == 4:
+		return collector.APIGraphQL
+	}
+	return *apiMode
+}
+
+// anonymized returns the target with its name and labels pseudonymized.
+func (t gridTarget) anonymized(anonymizer *collector.Anonymizer) gridTarget {
+	t.Name = anonymizer.Pseudonym(t.Name)
+	labels := make(map[string]string, len(t.Labels))
+	for k, v := range t.Labels {
+		labels[k] = anonymizer.Pseudonym(v)
+	}
+	t.Labels = labels
+	return t
+}
+
+// parseComponents parses a comma separated list of name=uri pairs.
+func parseComponents(value string) ([]collector.Component, error) {
+	var components []collector.Component
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, uri, ok := strings.Cut(pair, "=")
+		if !ok || name == "" || uri == "" {
+			return nil, fmt.Errorf("invalid component %q, expected name=uri", pair)
+		}
+		components = append(components, collector.Component{Name: name, URI: uri})
+	}
+	return components, nil
+}
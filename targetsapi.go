@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+
+	"github.com/wakeful/selenium_grid_exporter/collector"
+)
+
+// targetsResponse mirrors the response of the Prometheus /api/v1/targets API,
+// so existing tooling can read the scrape health of every Grid.
+type targetsResponse struct {
+	Status string `json:"status"`
+	Data   struct {
+		ActiveTargets  []activeTarget `json:"activeTargets"`
+		DroppedTargets []struct{}     `json:"droppedTargets"`
+	} `json:"data"`
+}
+
+type activeTarget struct {
+	DiscoveredLabels   map[string]string `json:"discoveredLabels"`
+	Labels             map[string]string `json:"labels"`
+	ScrapePool         string            `json:"scrapePool"`
+	ScrapeURL          string            `json:"scrapeUrl"`
+	LastError          string            `json:"lastError"`
+	LastScrape         time.Time         `json:"lastScrape"`
+	LastScrapeDuration float64           `json:"lastScrapeDuration"`
+	LastSuccess        *time.Time        `json:"lastSuccess,omitempty"`
+	Health             string            `json:"health"`
+	ScrapeInterval     string            `json:"scrapeInterval,omitempty"`
+	ScrapeTimeout      string            `json:"scrapeTimeout"`
+}
+
+/*
+newTargetsHandler lists the Grids with their labels and the state of their
+last scrape, following the semantics of the Prometheus targets page: health is
+up, down or unknown before the first scrape. The labels are the ones exported
+after the relabel rules which apply to every metric, the discovered labels the
+ones before. ?health=down keeps the targets of the given health and
+?grid=name a single Grid.
+*/
+func newTargetsHandler(rules []relabelRule, targets *collector.TargetSet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		grid := r.URL.Query().Get("grid")
+		health := r.URL.Query().Get("health")
+
+		resp := targetsResponse{Status: "success"}
+		resp.Data.ActiveTargets = []activeTarget{}
+		resp.Data.DroppedTargets = []struct{}{}
+		for _, e := range targets.List() {
+			if grid != "" && e.Name != grid {
+				continue
+			}
+			t := newActiveTarget(e.Status(), rules)
+			if health != "" && t.Health != health {
+				continue
+			}
+			resp.Data.ActiveTargets = append(resp.Data.ActiveTargets, t)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logrus.Errorf("Failed to write targets response: %v", err)
+		}
+	})
+}
+
+func newActiveTarget(status collector.Status, rules []relabelRule) activeTarget {
+	t := activeTarget{
+		DiscoveredLabels: status.Labels,
+		Labels:           relabelTarget(status.Labels, rules),
+		ScrapePool:       "selenium_grid",
+		ScrapeURL:        status.URI,
+		Health:           "unknown",
+		ScrapeTimeout:    status.Timeout.String(),
+	}
+	if status.ScrapeInterval > 0 {
+		t.ScrapeInterval = status.ScrapeInterval.String()
+	}
+	if !status.Scraped {
+		return t
+	}
+
+	t.Health = "down"
+	if status.Up {
+		t.Health = "up"
+	} else {
+		t.LastError = status.LastError
+	}
+	t.LastScrape = status.LastScrape
+	t.LastScrapeDuration = status.Duration.Seconds()
+	if !status.LastSuccess.IsZero() {
+		t.LastSuccess = &status.LastSuccess
+	}
+	return t
+}
+
+// relabelTarget applies the relabel rules without a metrics pattern, the
+// ones every series of the target goes through, to its labels.
+func relabelTarget(labels map[string]string, rules []relabelRule) map[string]string {
+	var global []relabelRule
+	for _, rule := range rules {
+		if rule.metrics == nil {
+			global = append(global, rule)
+		}
+	}
+
+	pairs := make([]*dto.LabelPair, 0, len(labels))
+	for name, value := range labels {
+		pairs = append(pairs, &dto.LabelPair{Name: &name, Value: &value})
+	}
+	relabeled := map[string]string{}
+	for _, l := range (&relabelGatherer{rules: global}).relabel("", pairs) {
+		relabeled[l.GetName()] = l.GetValue()
+	}
+	return relabeled
+}